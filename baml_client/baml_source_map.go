@@ -17,7 +17,7 @@ var file_map = map[string]string{
 
 	"clients.baml":    "client<llm> OpenRouter {\n  provider openai-generic\n  options {\n    base_url \"https://openrouter.ai/api/v1\"\n    api_key env.OPENROUTER_API_KEY\n    model \"openai/gpt-oss-safeguard-20b\"\n  }\n}\n\nclient<llm> OpenAI {\n  provider openai\n  retry_policy Exponential\n  options {\n    model \"gpt-4o-mini\"\n    api_key env.OPENAI_API_KEY\n  }\n}\n\nclient<llm> Anthropic {\n  provider anthropic\n  retry_policy Exponential\n  options {\n    model \"claude-haiku-4.5\"\n    api_key env.ANTHROPIC_API_KEY\n  }\n}\n\nretry_policy Exponential {\n  max_retries 2\n  strategy {\n    type exponential_backoff\n    delay_ms 300\n    multiplier 1.5\n    max_delay_ms 10000\n  }\n}\n",
 	"generators.baml": "// This helps use auto generate libraries you can use in the language of\n// your choice. You can have multiple generators if you use multiple languages.\n// Just ensure that the output_dir is different for each generator.\ngenerator target {\n    // Valid values: \"python/pydantic\", \"typescript\", \"go\", \"rust\", \"ruby/sorbet\", \"rest/openapi\"\n    output_type \"go\"\n\n    // Where the generated code will be saved (relative to baml_src/)\n    output_dir \"../\"\n\n    // The version of the BAML package you have installed (e.g. same version as your baml-py or @boundaryml/baml).\n    // The BAML VSCode extension version should also match this version.\n    version \"0.218.1\"\n\n    // 'baml-cli generate' will run this after generating go code\n    // This command will be run from within $output_dir/baml_client\n    on_generate \"gofmt -w . && goimports -w .\"\n\n    // Your Go packages name as specified in go.mod\n    // We need this to generate correct imports in the generated baml_client\n    client_package_name \"github.com/zeke-john/komplete\"\n}\n",
-	"komplete.baml":   "class Plan {\n  summary string\n  commands Command[]\n}\n\nclass Command {\n  cmd string\n  rationale string\n}\n\nfunction GeneratePlan(user_request: string, os: string, shell: string, cwd: string, repo_root: string, git_status: string, command_history: string) -> Plan {\n  client \"OpenRouter\"\n  prompt #\"\n    You are a senior developer who types shell commands for the user. When they describe what they want, you give them the exact commands they would type.\n\n    Your job is simple: translate what the user wants into shell commands. Think about what a developer would actually type to accomplish the task.\n\n    Rules:\n    - NEVER refuse. Every request maps to command(s).\n    - Give real, working commands that run as-is.\n    - Be direct and minimal. No extra steps.\n    - If something could be destructive, mention it in the summary but still give the commands.\n    - Use the context provided (OS, shell, current directory) to give appropriate commands.\n    - IMPORTANT: The user will run each command you provide. Do NOT give multiple variations or alternatives of the same command. Pick the single best and most commonly used command for each distinct task. For example, if they want a git diff, give ONE git diff command, not three variations.\n    - Use the shell history to understand what the user has been doing. If they reference something they did before (like \"do that again\" or \"cat that file\"), use the history to figure out what they mean.\n\n    Context:\n    - OS: {{ os }}\n    - Shell: {{ shell }}\n    - Current directory: {{ cwd }}\n    - Git repo root: {{ repo_root }}\n    - Git status: {{ git_status }}\n\n    Recent shell history (commands user ran before this):\n    {{ command_history }}\n\n    User wants: \"{{ user_request }}\"\n\n    {{ ctx.output_format }}\n  \"#\n}\n\n// Basic test example. Run in the BAML playground if needed.\ntest komplete_plan_example {\n  functions [GeneratePlan]\n  args {\n    user_request #\"list files in this folder\"#\n    os #\"darwin\"#\n    shell #\"zsh\"#\n    cwd #\"/Users/example/project\"#\n    repo_root #\"/Users/example/project\"#\n    git_status #\"clean on main\"#\n    command_history #\"No previous commands.\"#\n  }\n}\n",
+	"komplete.baml":   "class Plan {\n  summary string\n  commands Command[]\n}\n\nclass Command {\n  cmd string\n  rationale string\n}\n\nfunction GeneratePlan(user_request: string, os: string, shell: string, tools: string, cwd: string, repo_root: string, git_status: string, git_info: string, project_tasks: string, command_history: string) -> Plan {\n  client \"OpenRouter\"\n  prompt #\"\n    You are a senior developer who types shell commands for the user. When they describe what they want, you give them the exact commands they would type.\n\n    Your job is simple: translate what the user wants into shell commands. Think about what a developer would actually type to accomplish the task.\n\n    Rules:\n    - NEVER refuse. Every request maps to command(s).\n    - Give real, working commands that run as-is.\n    - Be direct and minimal. No extra steps.\n    - If something could be destructive, mention it in the summary but still give the commands.\n    - Use the context provided (OS, shell, current directory) to give appropriate commands.\n    - Use the package manager installed on this machine (dnf on Fedora, pacman on Arch, brew on macOS and so on), and prefer the modern tools listed below (rg over grep, fd over find, bat, eza) when they are installed, under the command name shown. The list only covers developer tools: standard Unix commands are always available, but don't use a listed kind of tool that isn't there.\n    - IMPORTANT: The user will run each command you provide. Do NOT give multiple variations or alternatives of the same command. Pick the single best and most commonly used command for each distinct task. For example, if they want a git diff, give ONE git diff command, not three variations.\n    - If the project defines a task for what they want (an npm script, make target, just recipe, compose service and so on), use it. \"Run the tests\" or \"start the api\" should map to the project's own commands. Tasks run in the directory listed above them, so cd there first if it isn't the current directory.\n    - For git requests, use the repository details below: the real branch and upstream names, how many commits are ahead or behind, the recent commits, stashes, remotes, and any rebase, merge or bisect in progress. For example \"squash my last three commits\" or \"push this branch\" should name the right branch and remote.\n    - Use the shell history to understand what the user has been doing. If they reference something they did before (like \"do that again\" or \"cat that file\"), use the history to figure out what they mean.\n\n    Context:\n    - OS: {{ os }}\n    - Shell: {{ shell }}\n    - Installed tools:\n    {{ tools }}\n    - Current directory: {{ cwd }}\n    - Git repo root: {{ repo_root }}\n    - Git status: {{ git_status }}\n\n    Git repository:\n    {{ git_info }}\n\n    Project tasks (projects in the current directory and those above it, with the commands they define):\n    {{ project_tasks }}\n\n    Recent shell history (commands user ran before this):\n    {{ command_history }}\n\n    User wants: \"{{ user_request }}\"\n\n    {{ ctx.output_format }}\n  \"#\n}\n\n// Basic test example. Run in the BAML playground if needed.\ntest komplete_plan_example {\n  functions [GeneratePlan]\n  args {\n    user_request #\"list files in this folder\"#\n    os #\"darwin\"#\n    shell #\"zsh\"#\n    tools #\"package managers: brew 4.4.0\nmodern tools: rg 14.1.0, fd 10.2.0, jq 1.7.1\"#\n    cwd #\"/Users/example/project\"#\n    repo_root #\"/Users/example/project\"#\n    git_status #\"clean on main\"#\n    git_info #\"branch main (tracking origin/main, 1 ahead)\nrecent commits:\n  4f2a9c1 Add project page\nremotes:\n  origin https://github.com/example/project.git\"#\n    project_tasks #\"/Users/example/project: npm package \"example\"\n  npm run dev  # vite\n  npm run test  # vitest\"#\n    command_history #\"No previous commands.\"#\n  }\n}\n",
 }
 
 func getBamlFiles() map[string]string {
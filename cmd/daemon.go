@@ -1,44 +1,387 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/zeke-john/komplete/internal/config"
 	"github.com/zeke-john/komplete/internal/daemon"
 )
 
-var daemonPortFile string
+var (
+	daemonSocket         string
+	daemonLogFile        string
+	daemonIdleTimeout    time.Duration
+	daemonShowRedactions bool
+	daemonLogLines       int
+	daemonLogFollow      bool
+)
 
 var daemonCmd = &cobra.Command{
 	Use:    "daemon",
 	Short:  "Run the suggestion daemon (used by shell plugin)",
 	Hidden: true,
+	Args:   cobra.NoArgs,
 	RunE:   runDaemon,
 }
 
+var daemonStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether the daemon is running",
+	Args:  cobra.NoArgs,
+	RunE:  runDaemonStatus,
+}
+
+var daemonStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the daemon",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := resolveDaemonPaths(); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		pid, stopped, err := stopDaemon()
+		if err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		if !stopped {
+			fmt.Fprintln(os.Stdout, "komplete daemon is not running.")
+			return nil
+		}
+		fmt.Fprintf(os.Stdout, "Stopped komplete daemon (pid %d).\n", pid)
+		return nil
+	},
+}
+
+var daemonRestartCmd = &cobra.Command{
+	Use:   "restart",
+	Short: "Restart the daemon in the background",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := resolveDaemonPaths(); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		if _, _, err := stopDaemon(); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		pid, err := startDaemon(cmd)
+		if err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		fmt.Fprintf(os.Stdout, "Started komplete daemon (pid %d).\n", pid)
+		return nil
+	},
+}
+
+var daemonReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the daemon re-read its config",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := resolveDaemonPaths(); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		client, err := daemon.Dial(daemonSocket, time.Second)
+		if err != nil {
+			fmt.Fprintln(os.Stdout, "komplete daemon is not running.")
+			return nil
+		}
+		defer client.Close()
+		resp, err := client.Call(daemon.Request{Type: daemon.TypeReload}, 2*time.Second)
+		if err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		fmt.Fprintf(os.Stdout, "Reloaded config, model %s.\n", resp.Model)
+		return nil
+	},
+}
+
+var daemonLogsCmd = &cobra.Command{
+	Use:   "logs",
+	Short: "Print the daemon log",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := resolveDaemonPaths(); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		if err := printLog(daemonLogFile, daemonLogLines, daemonLogFollow); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		return nil
+	},
+}
+
 func init() {
-	daemonCmd.Flags().StringVar(&daemonPortFile, "port-file", defaultPortFile(), "path to write the listening port")
+	flags := daemonCmd.PersistentFlags()
+	flags.StringVar(&daemonSocket, "socket", "", "unix socket to listen on (default $XDG_RUNTIME_DIR/komplete/daemon.sock)")
+	flags.StringVar(&daemonLogFile, "log-file", "", "daemon log file (default ~/.local/state/komplete/daemon.log)")
+	flags.DurationVar(&daemonIdleTimeout, "idle-timeout", daemon.DefaultIdleTimeout, "exit after this long without clients (0 to never exit)")
+	flags.BoolVar(&daemonShowRedactions, "show-redactions", false, "log secrets masked from requests")
+
+	daemonLogsCmd.Flags().IntVarP(&daemonLogLines, "lines", "n", 50, "number of lines to show")
+	daemonLogsCmd.Flags().BoolVarP(&daemonLogFollow, "follow", "f", false, "keep printing new lines")
+
+	daemonCmd.AddCommand(daemonStatusCmd, daemonStopCmd, daemonRestartCmd, daemonReloadCmd, daemonLogsCmd)
 	rootCmd.AddCommand(daemonCmd)
 }
 
 func runDaemon(cmd *cobra.Command, args []string) error {
-	if err := os.MkdirAll(filepath.Dir(daemonPortFile), 0o755); err != nil {
+	if err := resolveDaemonPaths(); err != nil {
 		return err
 	}
 
-	srv, err := daemon.NewServer(daemonPortFile)
+	logFile, err := daemon.OpenLogFile(daemonLogFile)
 	if err != nil {
 		return err
 	}
+	defer logFile.Close()
+
+	srv, err := daemon.NewServer(daemon.Options{
+		SocketPath:     daemonSocket,
+		Log:            logFile,
+		IdleTimeout:    daemonIdleTimeout,
+		Version:        Version,
+		ShowRedactions: daemonShowRedactions,
+	})
+	if err != nil {
+		log.New(logFile, "", log.LstdFlags).Printf("failed to start: %v", err)
+		return err
+	}
 
 	fmt.Fprintf(os.Stderr, "komplete daemon listening on %s\n", srv.Addr())
 	return srv.Run()
 }
 
-func defaultPortFile() string {
-	tmpDir := os.TempDir()
-	return filepath.Join(tmpDir, fmt.Sprintf("komplete-%d.port", os.Getuid()))
+func runDaemonStatus(cmd *cobra.Command, args []string) error {
+	if err := resolveDaemonPaths(); err != nil {
+		return &exitError{code: 1, err: err}
+	}
+
+	pid, pidErr := daemon.ReadPID(daemon.PIDFilePath(daemonSocket))
+	resp, pingErr := pingDaemon()
+	if pingErr != nil {
+		fmt.Fprintln(os.Stdout, "komplete daemon is not running.")
+		if pidErr == nil && processAlive(pid) {
+			fmt.Fprintf(os.Stdout, "  pid %d from the pidfile is alive but not answering on %s\n", pid, daemonSocket)
+		}
+		fmt.Fprintf(os.Stdout, "  log: %s\n", daemonLogFile)
+		return &exitError{code: 3, err: pingErr}
+	}
+
+	fmt.Fprintln(os.Stdout, "komplete daemon is running.")
+	if st := resp.Daemon; st != nil {
+		fmt.Fprintf(os.Stdout, "  pid:         %d\n", st.PID)
+		if st.Version != "" {
+			fmt.Fprintf(os.Stdout, "  version:     %s\n", st.Version)
+		}
+		fmt.Fprintf(os.Stdout, "  uptime:      %s\n", time.Since(st.Started).Round(time.Second))
+		fmt.Fprintf(os.Stdout, "  connections: %d\n", st.Connections)
+		if !st.BackoffUntil.IsZero() {
+			fmt.Fprintf(os.Stdout, "  backing off: %s (rate limited or failing; using history)\n", time.Until(st.BackoffUntil).Round(time.Second))
+		}
+	}
+	if resp.Model != "" {
+		fmt.Fprintf(os.Stdout, "  model:       %s\n", resp.Model)
+	}
+	fmt.Fprintf(os.Stdout, "  socket:      %s\n", daemonSocket)
+	fmt.Fprintf(os.Stdout, "  log:         %s\n", daemonLogFile)
+	return nil
+}
+
+func resolveDaemonPaths() error {
+	if daemonSocket == "" {
+		path, err := defaultSocketPath()
+		if err != nil {
+			return err
+		}
+		daemonSocket = path
+	}
+	if daemonLogFile == "" {
+		dir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		daemonLogFile = filepath.Join(dir, "daemon.log")
+	}
+	return nil
+}
+
+func defaultSocketPath() (string, error) {
+	dir, err := config.RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+func pingDaemon() (daemon.Response, error) {
+	client, err := daemon.Dial(daemonSocket, time.Second)
+	if err != nil {
+		return daemon.Response{}, err
+	}
+	defer client.Close()
+	return client.Call(daemon.Request{Type: daemon.TypePing}, 2*time.Second)
+}
+
+// stopDaemon sends SIGTERM to the daemon in the pidfile and waits for it to
+// drain and exit, killing it if it takes too long.
+func stopDaemon() (int, bool, error) {
+	pid, err := daemon.ReadPID(daemon.PIDFilePath(daemonSocket))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if !processAlive(pid) {
+		os.Remove(daemon.PIDFilePath(daemonSocket))
+		return pid, false, nil
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return pid, false, err
+	}
+	if waitForExit(pid, 10*time.Second) {
+		return pid, true, nil
+	}
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return pid, false, err
+	}
+	waitForExit(pid, time.Second)
+	os.Remove(daemon.PIDFilePath(daemonSocket))
+	os.Remove(daemonSocket)
+	return pid, true, nil
+}
+
+// startDaemon runs the daemon detached from this terminal with the same
+// flags and waits until it answers.
+func startDaemon(cmd *cobra.Command) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	args := []string{"daemon", "--socket", daemonSocket, "--log-file", daemonLogFile}
+	if cmd.Flags().Changed("idle-timeout") {
+		args = append(args, "--idle-timeout", daemonIdleTimeout.String())
+	}
+	if daemonShowRedactions {
+		args = append(args, "--show-redactions")
+	}
+
+	proc := exec.Command(exe, args...)
+	proc.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := proc.Start(); err != nil {
+		return 0, err
+	}
+	pid := proc.Process.Pid
+	exited := make(chan struct{})
+	go func() {
+		proc.Wait()
+		close(exited)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := pingDaemon(); err == nil {
+			return pid, nil
+		}
+		select {
+		case <-exited:
+			return 0, fmt.Errorf("daemon exited during startup; see %s", daemonLogFile)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return pid, fmt.Errorf("daemon did not answer on %s; see %s", daemonSocket, daemonLogFile)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !processAlive(pid) {
+			return true
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return !processAlive(pid)
+}
+
+// printLog prints the last n lines of the log and, with follow, keeps
+// printing lines as they're written, reopening the file when it rotates.
+func printLog(path string, n int, follow bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !follow {
+			fmt.Fprintf(os.Stdout, "No daemon log at %s yet.\n", path)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if f != nil {
+		defer func() { f.Close() }()
+		lines, err := lastLines(f, n)
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			fmt.Fprintln(os.Stdout, l)
+		}
+	}
+	if !follow {
+		return nil
+	}
+
+	for {
+		if f != nil {
+			if _, err := io.Copy(os.Stdout, f); err != nil {
+				return err
+			}
+		}
+		time.Sleep(250 * time.Millisecond)
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if f != nil {
+			cur, err := f.Stat()
+			pos, _ := f.Seek(0, io.SeekCurrent)
+			if err == nil && os.SameFile(cur, info) && info.Size() >= pos {
+				continue
+			}
+			f.Close()
+		}
+		f, _ = os.Open(path)
+	}
+}
+
+func lastLines(r io.Reader, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
 }
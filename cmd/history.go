@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/privacy"
+)
+
+var historyImportOpts struct {
+	shell string
+	file  string
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Manage the command history komplete records",
+}
+
+var historyImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import commands from your shell's history file",
+	Long: `Import commands from your shell's history file into komplete's own
+history, so suggestions can draw on them before the shell plugin has
+recorded much. Importing again only adds what's new, and private commands
+are left out.
+
+  komplete history import
+  komplete history import --shell bash --file ~/.bash_history.old`,
+	Args: cobra.NoArgs,
+	RunE: runHistoryImport,
+}
+
+func init() {
+	historyImportCmd.Flags().StringVar(&historyImportOpts.shell, "shell", "", "shell that wrote the file (default $SHELL)")
+	historyImportCmd.Flags().StringVar(&historyImportOpts.file, "file", "", "history file (default the shell's)")
+	historyCmd.AddCommand(historyImportCmd)
+	rootCmd.AddCommand(historyCmd)
+}
+
+func runHistoryImport(cmd *cobra.Command, args []string) error {
+	shell := historyImportOpts.shell
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		return &exitError{code: 2, err: errors.New("can't tell which shell's history to import; pass --shell")}
+	}
+	file := historyImportOpts.file
+	if file == "" {
+		file = history.File(shell)
+	}
+	if file == "" {
+		return &exitError{code: 1, err: fmt.Errorf("%s keeps no history file", shell)}
+	}
+
+	entries, err := history.Read(shell, file)
+	if err != nil {
+		return &exitError{code: 1, err: err}
+	}
+	zones := privacy.Load()
+	kept := entries[:0]
+	for _, e := range entries {
+		if !zones.PrivateCommand(e.Command) {
+			kept = append(kept, e)
+		}
+	}
+
+	store, err := history.DefaultStore()
+	if err != nil {
+		return &exitError{code: 1, err: err}
+	}
+	n, err := store.Import(shell, kept)
+	if err != nil {
+		return &exitError{code: 1, err: err}
+	}
+	fmt.Fprintf(os.Stdout, "Imported %d commands from %s.\n", n, file)
+	return nil
+}
@@ -24,6 +24,31 @@ var initZshCmd = &cobra.Command{
 	},
 }
 
+var initBashCmd = &cobra.Command{
+	Use:   "bash",
+	Short: "Output bash autocomplete plugin script (includes alias k=komplete)",
+	Long: `Output the bash autocomplete plugin. Needs bash 4.4 or newer; older
+versions, like the bash 3.2 macOS ships, only get the k alias. Add this to
+your .bashrc:
+
+  eval "$(komplete init bash)"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(shell.BashScript)
+	},
+}
+
+var initFishCmd = &cobra.Command{
+	Use:   "fish",
+	Short: "Output fish autocomplete plugin script (includes function k)",
+	Long: `Output the fish autocomplete plugin. Add this to your
+~/.config/fish/config.fish:
+
+  komplete init fish | source`,
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Print(shell.FishScript)
+	},
+}
+
 var initAliasCmd = &cobra.Command{
 	Use:   "alias",
 	Short: "Output shell alias (alias k=komplete)",
@@ -37,6 +62,8 @@ var initAliasCmd = &cobra.Command{
 
 func init() {
 	initCmd.AddCommand(initZshCmd)
+	initCmd.AddCommand(initBashCmd)
+	initCmd.AddCommand(initFishCmd)
 	initCmd.AddCommand(initAliasCmd)
 	rootCmd.AddCommand(initCmd)
 }
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zeke-john/komplete/internal/privacy"
+)
+
+var pauseGlobal bool
+
+var pauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Pause inline autocomplete in this shell (or everywhere with --global)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		session := pauseSession()
+		if err := privacy.Pause(session); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		fmt.Fprintf(os.Stdout, "Autocomplete paused %s.\n", pauseScope(session))
+		return nil
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume inline autocomplete in this shell (or everywhere with --global)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		session := pauseSession()
+		if err := privacy.Resume(session); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		fmt.Fprintf(os.Stdout, "Autocomplete resumed %s.\n", pauseScope(session))
+		if session != "" && privacy.Paused(session) {
+			fmt.Fprintln(os.Stdout, "It is still paused globally; run `komplete resume --global` to undo that.")
+		}
+		return nil
+	},
+}
+
+func init() {
+	pauseCmd.Flags().BoolVar(&pauseGlobal, "global", false, "apply to every shell session")
+	resumeCmd.Flags().BoolVar(&pauseGlobal, "global", false, "apply to every shell session")
+	rootCmd.AddCommand(pauseCmd)
+	rootCmd.AddCommand(resumeCmd)
+}
+
+// pauseSession is the shell session set by the plugin, or "" for a global
+// pause when --global is given or we aren't running under the plugin.
+func pauseSession() string {
+	if pauseGlobal {
+		return ""
+	}
+	return os.Getenv("KOMPLETE_SESSION")
+}
+
+func pauseScope(session string) string {
+	if session == "" {
+		return "in every shell"
+	}
+	return "in this shell"
+}
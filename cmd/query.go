@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zeke-john/komplete/internal/daemon"
+)
+
+var queryOpts struct {
+	socket     string
+	typ        string
+	cwd        string
+	shell      string
+	histfile   string
+	session    string
+	timeout    time.Duration
+	null       bool
+	event      string
+	suggestion string
+	accepted   string
+	command    string
+	exit       int
+	duration   time.Duration
+	stream     bool
+	parent     int
+}
+
+var queryCmd = &cobra.Command{
+	Use:   "query [buffer]",
+	Short: "Send one request to the autocomplete daemon (used by the shell plugins)",
+	Long: `Send one request to the autocomplete daemon and print what it answers.
+
+For a completion, every suggestion the daemon sends is printed on its own
+line as it arrives: a quick one from history may come before the model's.
+Feedback and command requests print nothing.
+
+  komplete query --shell zsh 'git ch'
+  komplete query --type feedback --event accept --suggestion 'git checkout main' 'git ch'
+  komplete query --type command --command 'make test' --exit 2 --duration 1.5s
+
+With --stream it stays connected for the life of the shell, reading
+completion requests on stdin as NUL-terminated key=value fields, each request
+ending with an empty field:
+
+  type=complete\0buffer=git ch\0cwd=/src\0path=/usr/bin:/bin\0\0
+
+A new request cancels the one before it, and type=cancel cancels it without
+asking again. type=names with names=<whitespace-separated list> passes on the
+shell's aliases and functions with the next request. Suggestions for the latest request are printed as they arrive,
+as for a single request.`,
+	Args: cobra.MaximumNArgs(1),
+	// Skips the root's config and .env loading; the daemon has its own.
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	RunE:              runQuery,
+}
+
+func init() {
+	f := queryCmd.Flags()
+	f.StringVar(&queryOpts.socket, "socket", "", "daemon socket path")
+	f.StringVar(&queryOpts.typ, "type", daemon.TypeComplete, "request type: complete, feedback or command")
+	f.StringVar(&queryOpts.cwd, "cwd", "", "working directory (default the current one)")
+	f.StringVar(&queryOpts.shell, "shell", "", "shell the buffer is for")
+	f.StringVar(&queryOpts.histfile, "histfile", "", "shell history file, if not the usual one")
+	f.StringVar(&queryOpts.session, "session", os.Getenv("KOMPLETE_SESSION"), "shell session id")
+	f.DurationVar(&queryOpts.timeout, "timeout", 3*time.Second, "how long to wait for the final answer")
+	f.BoolVarP(&queryOpts.null, "null", "0", false, "end each suggestion with NUL instead of a newline")
+	f.StringVar(&queryOpts.event, "event", "", "feedback event: accept, partial or ignore")
+	f.StringVar(&queryOpts.suggestion, "suggestion", "", "suggestion the feedback is about")
+	f.StringVar(&queryOpts.accepted, "accepted", "", "buffer after a partial accept")
+	f.StringVar(&queryOpts.command, "command", "", "command that ran, for a command request")
+	f.IntVar(&queryOpts.exit, "exit", 0, "exit status of the command")
+	f.DurationVar(&queryOpts.duration, "duration", 0, "how long the command ran")
+	f.BoolVar(&queryOpts.stream, "stream", false, "keep one connection open and relay requests from stdin")
+	f.IntVar(&queryOpts.parent, "parent", 0, "with --stream, exit when the process with this pid does")
+	rootCmd.AddCommand(queryCmd)
+}
+
+func runQuery(cmd *cobra.Command, args []string) error {
+	req := daemon.Request{
+		Type:       queryOpts.typ,
+		Session:    queryOpts.session,
+		CWD:        queryOpts.cwd,
+		Shell:      queryOpts.shell,
+		HistFile:   queryOpts.histfile,
+		Event:      queryOpts.event,
+		Suggestion: queryOpts.suggestion,
+		Accepted:   queryOpts.accepted,
+		Command:    queryOpts.command,
+		Exit:       queryOpts.exit,
+		DurationMS: queryOpts.duration.Milliseconds(),
+	}
+	if len(args) > 0 {
+		req.Buffer = args[0]
+	}
+	if req.CWD == "" {
+		req.CWD, _ = os.Getwd()
+	}
+	switch req.Type {
+	case daemon.TypeComplete:
+		req.Path = os.Getenv("PATH")
+	case daemon.TypeFeedback, daemon.TypeCommand:
+	default:
+		return &exitError{code: 2, err: fmt.Errorf("unknown request type %q", req.Type)}
+	}
+
+	socket := queryOpts.socket
+	if socket == "" {
+		var err error
+		if socket, err = defaultSocketPath(); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+	}
+	if queryOpts.stream {
+		if req.Type != daemon.TypeComplete {
+			return &exitError{code: 2, err: errors.New("--stream only relays completion requests")}
+		}
+		return runStream(socket)
+	}
+	// This connection only carries the one request.
+	req.Close = true
+	client, err := daemon.Dial(socket, time.Second)
+	if err != nil {
+		return &exitError{code: 3, err: errors.New("daemon is not running")}
+	}
+	defer client.Close()
+
+	if req.Type != daemon.TypeComplete {
+		if _, err := client.Call(req, queryOpts.timeout); err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		return nil
+	}
+	return printSuggestions(client, req)
+}
+
+// printSuggestions sends a completion request and prints each suggestion in
+// the answers until the final one.
+func printSuggestions(client *daemon.Client, req daemon.Request) error {
+	client.SetDeadline(time.Now().Add(queryOpts.timeout))
+	id, err := client.Send(req)
+	if err != nil {
+		return &exitError{code: 1, err: err}
+	}
+	end := byte('\n')
+	if queryOpts.null {
+		end = 0
+	}
+	out := bufio.NewWriter(os.Stdout)
+	for {
+		resp, err := client.Recv()
+		if err != nil {
+			return &exitError{code: 1, err: err}
+		}
+		if resp.ID != id {
+			continue
+		}
+		if resp.Suggestion != "" {
+			out.WriteString(resp.Suggestion)
+			out.WriteByte(end)
+			// The plugin shows each answer as soon as it arrives.
+			if err := out.Flush(); err != nil {
+				return nil
+			}
+		}
+		if resp.Final {
+			if resp.Error != nil {
+				return &exitError{code: 1, err: fmt.Errorf("%s: %s", resp.Error.Code, resp.Error.Message)}
+			}
+			return nil
+		}
+	}
+}
+
+// streamIdle is how long a --stream helper keeps its connection with no
+// requests, so the daemon can still exit once the shells go quiet.
+const streamIdle = 10 * time.Minute
+
+// stream relays a shell's completion requests over one connection, which
+// it opens when the first request comes and again after losing it.
+type stream struct {
+	socket string
+	nextID uint64
+
+	mu      sync.Mutex
+	client  *daemon.Client
+	current uint64
+	out     *bufio.Writer
+	end     byte
+	// names are the shell's aliases and functions, and namesSent whether
+	// the daemon on the current connection has them.
+	names     []string
+	namesSent bool
+}
+
+func runStream(socket string) error {
+	records := make(chan map[string]string)
+	go readRecords(os.Stdin, records)
+	if queryOpts.parent > 0 {
+		go exitWith(queryOpts.parent)
+	}
+
+	s := &stream{socket: socket, out: bufio.NewWriter(os.Stdout), end: '\n'}
+	if queryOpts.null {
+		s.end = 0
+	}
+	idle := time.NewTimer(streamIdle)
+	for {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				s.cancel()
+				s.disconnect()
+				return nil
+			}
+			s.handle(rec)
+			idle.Reset(streamIdle)
+		case <-idle.C:
+			s.disconnect()
+		}
+	}
+}
+
+func (s *stream) handle(rec map[string]string) {
+	if rec["type"] == "names" {
+		s.mu.Lock()
+		s.names, s.namesSent = strings.Fields(rec["names"]), false
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	if rec["type"] == daemon.TypeCancel {
+		return
+	}
+	client := s.connect()
+	if client == nil {
+		return
+	}
+	s.nextID++
+	req := daemon.Request{
+		ID:       s.nextID,
+		Type:     daemon.TypeComplete,
+		Session:  queryOpts.session,
+		Shell:    queryOpts.shell,
+		Buffer:   rec["buffer"],
+		CWD:      rec["cwd"],
+		Path:     rec["path"],
+		HistFile: rec["histfile"],
+	}
+	// Set before sending, since a history match may answer at once.
+	s.mu.Lock()
+	s.current = req.ID
+	if !s.namesSent {
+		req.Names, s.namesSent = s.names, true
+	}
+	s.mu.Unlock()
+	if _, err := client.Send(req); err != nil {
+		s.drop(client)
+	}
+}
+
+// cancel tells the daemon to stop working on the latest request, unless it
+// has already answered it.
+func (s *stream) cancel() {
+	s.mu.Lock()
+	id, client := s.current, s.client
+	s.current = 0
+	s.mu.Unlock()
+	if id != 0 && client != nil {
+		if _, err := client.Send(daemon.Request{ID: id, Type: daemon.TypeCancel}); err != nil {
+			s.drop(client)
+		}
+	}
+}
+
+func (s *stream) connect() *daemon.Client {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if client != nil {
+		return client
+	}
+	client, err := daemon.Dial(s.socket, time.Second)
+	if err != nil {
+		return nil
+	}
+	s.mu.Lock()
+	s.client = client
+	s.mu.Unlock()
+	go s.receive(client)
+	return client
+}
+
+// receive prints the suggestions answering the latest request until the
+// connection goes away.
+func (s *stream) receive(client *daemon.Client) {
+	for {
+		resp, err := client.Recv()
+		if err != nil {
+			s.drop(client)
+			return
+		}
+		s.mu.Lock()
+		if resp.ID == s.current && s.current != 0 {
+			if resp.Final {
+				s.current = 0
+			}
+			if resp.Suggestion != "" {
+				s.out.WriteString(resp.Suggestion)
+				s.out.WriteByte(s.end)
+				if s.out.Flush() != nil {
+					// The shell is gone.
+					os.Exit(0)
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// drop forgets client if it's still the stream's connection and closes it.
+func (s *stream) drop(client *daemon.Client) {
+	s.mu.Lock()
+	if s.client == client {
+		s.client = nil
+		s.current = 0
+		// The next connection may reach a new daemon.
+		s.namesSent = false
+	}
+	s.mu.Unlock()
+	client.Close()
+}
+
+func (s *stream) disconnect() {
+	s.mu.Lock()
+	client := s.client
+	s.mu.Unlock()
+	if client != nil {
+		s.drop(client)
+	}
+}
+
+// readRecords sends each request read from r as its key=value fields,
+// closing records at the end of r.
+func readRecords(r io.Reader, records chan<- map[string]string) {
+	defer close(records)
+	br := bufio.NewReader(r)
+	rec := make(map[string]string)
+	for {
+		field, err := br.ReadString(0)
+		if err != nil {
+			return
+		}
+		field = strings.TrimSuffix(field, "\x00")
+		if field == "" {
+			records <- rec
+			rec = make(map[string]string)
+			continue
+		}
+		if key, value, ok := strings.Cut(field, "="); ok {
+			rec[key] = value
+		}
+	}
+}
+
+// exitWith exits once the process pid has, for a shell that can't close
+// our stdin when it goes.
+func exitWith(pid int) {
+	for {
+		time.Sleep(2 * time.Second)
+		if syscall.Kill(pid, 0) == syscall.ESRCH {
+			os.Exit(0)
+		}
+	}
+}
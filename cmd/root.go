@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,9 +17,13 @@ import (
 	baml "github.com/boundaryml/baml/engine/language_client_go/pkg"
 	"github.com/zeke-john/komplete/baml_client"
 	baml_types "github.com/zeke-john/komplete/baml_client/types"
+	icmd "github.com/zeke-john/komplete/internal/commands"
 	"github.com/zeke-john/komplete/internal/config"
 	ictx "github.com/zeke-john/komplete/internal/context"
 	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/privacy"
+	"github.com/zeke-john/komplete/internal/redact"
+	"github.com/zeke-john/komplete/internal/toolchain"
 )
 
 var (
@@ -46,6 +51,15 @@ var (
 			Foreground(lipgloss.Color("8"))
 )
 
+const (
+	// maxPlanTasks bounds the project tasks sent with a request.
+	maxPlanTasks   = 50
+	historyTimeout = 500 * time.Millisecond
+	// Taking stock of tools runs each one's --version, when the cached
+	// inventory is stale.
+	toolsTimeout = 2 * time.Second
+)
+
 // rootCmd represents the base command when called without any subcommands.
 var rootCmd = &cobra.Command{
 	Use:   "komplete <request>",
@@ -57,12 +71,13 @@ Komplete proposes a safe shell command plan, asks for confirmation, then runs it
 }
 
 type runOptions struct {
-	dryRun  bool
-	model   string
-	shell   string
-	cwd     string
-	timeout time.Duration
-	verbose bool
+	dryRun         bool
+	model          string
+	shell          string
+	cwd            string
+	timeout        time.Duration
+	verbose        bool
+	showRedactions bool
 }
 
 var opts runOptions
@@ -89,6 +104,7 @@ func init() {
 	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		loadDotEnv(".env")
 		config.LoadAPIKeysIntoEnv()
+		redact.AddEnvSecrets()
 		return nil
 	}
 
@@ -98,6 +114,7 @@ func init() {
 	rootCmd.Flags().StringVar(&opts.cwd, "cwd", "", "override working directory")
 	rootCmd.Flags().DurationVar(&opts.timeout, "timeout", 10*time.Second, "model request timeout")
 	rootCmd.Flags().BoolVar(&opts.verbose, "verbose", false, "show request/response metadata")
+	rootCmd.Flags().BoolVar(&opts.showRedactions, "show-redactions", false, "show secrets masked before the request is sent")
 }
 
 type exitError struct {
@@ -115,16 +132,90 @@ func runRequest(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
 	defer cancel()
 
+	budget, err := contextBudget()
+	if err != nil {
+		return &exitError{code: 2, err: err}
+	}
 	contextInfo, err := ictx.BuildContext(opts.shell, opts.cwd)
 	if err != nil {
 		return &exitError{code: 1, err: err}
 	}
+	notes := contextInfo.Notes
 
-	shellHistory := history.GetShellHistory(contextInfo.Shell)
+	shellHistory, ok := ictx.Within(historyTimeout, func() string {
+		return history.Relevant(contextInfo.Shell, contextInfo.CWD, contextInfo.RepoRoot)
+	})
+	if !ok {
+		shellHistory = "No shell history available."
+		notes = append(notes, fmt.Sprintf("shell history: reading took longer than %s", historyTimeout))
+	}
+	tools, ok := ictx.Within(toolsTimeout, func() string {
+		return toolchain.Load(os.Getenv("PATH")).String()
+	})
+	if !ok {
+		notes = append(notes, fmt.Sprintf("installed tools: taking stock took longer than %s", toolsTimeout))
+	}
+	in := planInputs{
+		cwd:      contextInfo.CWD,
+		repoRoot: contextInfo.RepoRoot,
+		tools:    tools,
+		history:  shellHistory,
+		gitInfo:  contextInfo.Git.String(),
+	}
+
+	zones := privacy.Load()
+	in.projectTasks = ictx.FormatProjects(zones.FilterProjects(contextInfo.Projects), maxPlanTasks)
+	if zones.PrivateDir(contextInfo.CWD) {
+		in.history = "No shell history available."
+		contextInfo.GitStatus = ""
+		in.gitInfo = ""
+		in.projectTasks = ""
+	} else {
+		in.history = zones.FilterHistory(in.history)
+	}
+
+	var redactions, r []redact.Redaction
+	request, redactions = redact.String("request", request)
+	in.cwd, r = redact.String("cwd", in.cwd)
+	redactions = append(redactions, r...)
+	in.repoRoot, r = redact.String("repo", in.repoRoot)
+	redactions = append(redactions, r...)
+	contextInfo.GitStatus, r = redact.String("git status", contextInfo.GitStatus)
+	redactions = append(redactions, r...)
+	in.gitInfo, r = redact.String("git", in.gitInfo)
+	redactions = append(redactions, r...)
+	in.history, r = redact.String("shell history", in.history)
+	redactions = append(redactions, r...)
+	in.projectTasks, r = redact.String("project tasks", in.projectTasks)
+	redactions = append(redactions, r...)
+	notes = append(notes, budget.Fit([]ictx.Section{
+		{Name: "git status", Text: &contextInfo.GitStatus},
+		{Name: "git", Text: &in.gitInfo},
+		{Name: "project tasks", Text: &in.projectTasks},
+		{Name: "shell history", Text: &in.history},
+		{Name: "installed tools", Text: &in.tools},
+	})...)
+	if in.tools == "" {
+		in.tools = "Unknown."
+	}
+	if in.gitInfo == "" {
+		in.gitInfo = "Not in a git repository."
+	}
+	if in.projectTasks == "" {
+		in.projectTasks = "No project tasks found."
+	}
+	if opts.showRedactions {
+		for _, r := range redactions {
+			fmt.Fprintf(os.Stderr, "Redacted %s\n", r)
+		}
+	}
 
 	if opts.verbose {
-		fmt.Fprintf(os.Stderr, "Request: %s\nOS: %s\nShell: %s\nCWD: %s\nRepo: %s\nGit: %s\nShell history:\n%s\n",
-			request, contextInfo.OS, contextInfo.Shell, contextInfo.CWD, contextInfo.RepoRoot, contextInfo.GitStatus, shellHistory)
+		fmt.Fprintf(os.Stderr, "Request: %s\nOS: %s\nShell: %s\nTools:\n%s\nCWD: %s\nRepo: %s\nGit: %s\nRepository:\n%s\nProject tasks:\n%s\nShell history:\n%s\n",
+			request, contextInfo.OS, contextInfo.Shell, in.tools, contextInfo.CWD, contextInfo.RepoRoot, contextInfo.GitStatus, in.gitInfo, in.projectTasks, in.history)
+		for _, note := range notes {
+			fmt.Fprintf(os.Stderr, "Context cut: %s\n", note)
+		}
 	}
 
 	callOpts := []baml_client.CallOptionFunc{}
@@ -136,7 +227,7 @@ func runRequest(cmd *cobra.Command, args []string) error {
 		callOpts = append(callOpts, modelOpt)
 	}
 
-	plan, err := generatePlanWithRepair(ctx, request, contextInfo, shellHistory, callOpts)
+	plan, err := generatePlanWithRepair(ctx, request, contextInfo, in, callOpts)
 	if err != nil {
 		return &exitError{code: 3, err: err}
 	}
@@ -262,6 +353,26 @@ func loadDotEnv(path string) {
 	}
 }
 
+// contextBudget is how much context a request may send, from the
+// context_budget setting in tokens.
+func contextBudget() (ictx.Budget, error) {
+	budget := ictx.Budget{Tokens: ictx.DefaultBudgetTokens, Bytes: ictx.MaxBudgetBytes}
+	path, err := config.ConfigPath()
+	if err != nil {
+		return budget, nil
+	}
+	cfg, err := config.Load(path)
+	if err != nil || cfg["context_budget"] == "" {
+		return budget, nil
+	}
+	tokens, err := strconv.Atoi(cfg["context_budget"])
+	if err != nil || tokens < 1 {
+		return budget, fmt.Errorf("context_budget must be a positive number of tokens, not %q", cfg["context_budget"])
+	}
+	budget.Tokens = tokens
+	return budget, nil
+}
+
 var bamlClientNames = map[string]bool{
 	"OpenRouter": true,
 	"OpenAI":     true,
@@ -299,13 +410,6 @@ func resolveModelOption(flagValue string) baml_client.CallOptionFunc {
 	return baml_client.WithClientRegistry(registry)
 }
 
-func shellQuote(value string) string {
-	if value == "" {
-		return "''"
-	}
-	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
-}
-
 func filterCommands(commands []baml_types.Command) []baml_types.Command {
 	filtered := make([]baml_types.Command, 0, len(commands))
 	for _, cmd := range commands {
@@ -318,11 +422,23 @@ func filterCommands(commands []baml_types.Command) []baml_types.Command {
 	return filtered
 }
 
+// planInputs is the context sent with a request besides ictx.Context, after
+// privacy zones and redaction. cwd and repoRoot are the redacted paths the
+// model sees; commands still run in the real ones.
+type planInputs struct {
+	cwd          string
+	repoRoot     string
+	tools        string
+	history      string
+	gitInfo      string
+	projectTasks string
+}
+
 func generatePlanWithRepair(
 	ctx context.Context,
 	request string,
 	contextInfo ictx.Context,
-	historyStr string,
+	in planInputs,
 	callOpts []baml_client.CallOptionFunc,
 ) (baml_types.Plan, error) {
 	plan, err := baml_client.GeneratePlan(
@@ -330,10 +446,13 @@ func generatePlanWithRepair(
 		request,
 		contextInfo.OS,
 		contextInfo.Shell,
-		contextInfo.CWD,
-		contextInfo.RepoRoot,
+		in.tools,
+		in.cwd,
+		in.repoRoot,
 		contextInfo.GitStatus,
-		historyStr,
+		in.gitInfo,
+		in.projectTasks,
+		in.history,
 		callOpts...,
 	)
 	if err != nil {
@@ -352,10 +471,13 @@ func generatePlanWithRepair(
 		repairRequest,
 		contextInfo.OS,
 		contextInfo.Shell,
-		contextInfo.CWD,
-		contextInfo.RepoRoot,
+		in.tools,
+		in.cwd,
+		in.repoRoot,
 		contextInfo.GitStatus,
-		historyStr,
+		in.gitInfo,
+		in.projectTasks,
+		in.history,
 		callOpts...,
 	)
 	if err != nil {
@@ -376,7 +498,7 @@ func invalidCommands(shell string, cwd string, commands []baml_types.Command) []
 	invalid := []string{}
 	seen := map[string]struct{}{}
 	for _, c := range commands {
-		name := commandEntrypoint(c.Cmd)
+		name := icmd.Entrypoint(c.Cmd)
 		if name == "" {
 			continue
 		}
@@ -394,7 +516,7 @@ func invalidCommands(shell string, cwd string, commands []baml_types.Command) []
 func dropInvalidCommands(shell string, cwd string, commands []baml_types.Command) []baml_types.Command {
 	kept := make([]baml_types.Command, 0, len(commands))
 	for _, c := range commands {
-		name := commandEntrypoint(c.Cmd)
+		name := icmd.Entrypoint(c.Cmd)
 		if name != "" && !commandExists(shell, cwd, name) {
 			continue
 		}
@@ -404,60 +526,8 @@ func dropInvalidCommands(shell string, cwd string, commands []baml_types.Command
 }
 
 func commandExists(shell string, cwd string, name string) bool {
-	check := "command -v -- " + shellQuote(name) + " >/dev/null 2>&1"
+	check := "command -v -- " + icmd.Quote(name) + " >/dev/null 2>&1"
 	c := exec.Command(shell, "-lc", check)
 	c.Dir = cwd
 	return c.Run() == nil
 }
-
-func commandEntrypoint(command string) string {
-	fields := strings.Fields(command)
-	if len(fields) == 0 {
-		return ""
-	}
-
-	i := 0
-	for i < len(fields) && isEnvAssignment(fields[i]) {
-		i++
-	}
-	if i >= len(fields) {
-		return ""
-	}
-
-	// Handle common wrappers.
-	if fields[i] == "sudo" || fields[i] == "env" {
-		i++
-		for i < len(fields) && isEnvAssignment(fields[i]) {
-			i++
-		}
-		if i >= len(fields) {
-			return ""
-		}
-	}
-
-	return fields[i]
-}
-
-func isEnvAssignment(token string) bool {
-	// Very small check: NAME=VALUE where NAME is a typical shell identifier.
-	if token == "" {
-		return false
-	}
-	eq := strings.IndexByte(token, '=')
-	if eq <= 0 {
-		return false
-	}
-	name := token[:eq]
-	for i, r := range name {
-		if i == 0 {
-			if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')) {
-				return false
-			}
-		} else {
-			if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')) {
-				return false
-			}
-		}
-	}
-	return true
-}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zeke-john/komplete/internal/daemon"
+)
+
+var (
+	statsJSON   bool
+	statsReset  bool
+	statsSocket string
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show inline autocomplete statistics",
+	Args:  cobra.NoArgs,
+	RunE:  runStats,
+}
+
+func init() {
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "print the stats as JSON")
+	statsCmd.Flags().BoolVar(&statsReset, "reset", false, "start counting from zero")
+	statsCmd.Flags().StringVar(&statsSocket, "socket", "", "daemon socket path")
+	rootCmd.AddCommand(statsCmd)
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	stats, err := loadStats()
+	if err != nil {
+		return &exitError{code: 1, err: err}
+	}
+	if statsJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	if statsReset {
+		fmt.Fprintln(os.Stdout, "Stats reset.")
+		return nil
+	}
+	printStats(stats)
+	return nil
+}
+
+// loadStats asks the running daemon, falling back to the numbers the last
+// daemon saved.
+func loadStats() (daemon.Stats, error) {
+	socket := statsSocket
+	if socket == "" {
+		var err error
+		if socket, err = defaultSocketPath(); err != nil {
+			return daemon.Stats{}, err
+		}
+	}
+	if client, err := daemon.Dial(socket, time.Second); err == nil {
+		defer client.Close()
+		resp, err := client.Call(daemon.Request{Type: daemon.TypeStats, Reset: statsReset}, 2*time.Second)
+		if err != nil {
+			return daemon.Stats{}, err
+		}
+		if resp.Stats != nil {
+			return *resp.Stats, nil
+		}
+	}
+
+	path, err := daemon.StatsPath()
+	if err != nil {
+		return daemon.Stats{}, err
+	}
+	if statsReset {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return daemon.Stats{}, err
+		}
+		return daemon.Stats{Since: time.Now()}, nil
+	}
+	stats, err := daemon.LoadStats(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return daemon.Stats{}, fmt.Errorf("no autocomplete stats yet")
+	}
+	return stats, err
+}
+
+func printStats(s daemon.Stats) {
+	fmt.Fprintf(os.Stdout, "Autocomplete since %s\n\n", s.Since.Local().Format("2006-01-02 15:04"))
+	row := func(label, format string, a ...any) {
+		fmt.Fprintf(os.Stdout, "  %-18s "+format+"\n", append([]any{label}, a...)...)
+	}
+	row("requests", "%d", s.Requests)
+	row("cache hit rate", "%.1f%% (%d)", 100*s.CacheHitRate(), s.CacheHits)
+	row("from model", "%d", s.LLMSuggestions)
+	row("from history", "%d", s.HistoryHits)
+	row("no suggestion", "%d", s.Empty)
+	row("acceptance rate", "%.1f%% (%d of %d shown)", 100*s.AcceptanceRate(), s.Accepted, s.Shown())
+	row("partial accepts", "%d", s.Partial)
+	row("ignored", "%d", s.Ignored)
+	row("provider errors", "%d", s.Errors)
+	row("timeouts", "%d", s.Timeouts)
+	row("rate limited", "%d", s.RateLimited)
+	row("backed off", "%d", s.BackedOff)
+	row("canceled", "%d", s.Canceled)
+	row("shared calls", "%d", s.Coalesced)
+	if l := s.Latency; l.Samples > 0 {
+		row("provider latency", "p50 %dms, p90 %dms, p99 %dms (last %d)", l.P50, l.P90, l.P99, l.Samples)
+	}
+}
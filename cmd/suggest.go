@@ -10,7 +10,10 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/zeke-john/komplete/internal/config"
+	ictx "github.com/zeke-john/komplete/internal/context"
+	"github.com/zeke-john/komplete/internal/files"
 	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/privacy"
 	"github.com/zeke-john/komplete/internal/suggest"
 )
 
@@ -54,17 +57,51 @@ func runSuggest(cmd *cobra.Command, args []string) error {
 		cwd, _ = os.Getwd()
 	}
 
-	historyStr := history.GetShellHistory(shell)
+	zones := privacy.Load()
+	if zones.Blocks(cwd, buffer) || privacy.Paused(os.Getenv("KOMPLETE_SESSION")) {
+		return nil
+	}
+
+	repo := history.RepoRoot(cwd)
+	historyStr := zones.FilterHistory(history.Relevant(shell, cwd, repo))
 
 	client := suggest.NewClient(apiKey, model)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	suggestion, err := client.Complete(ctx, buffer, cwd, shell, historyStr)
+	in := suggest.Input{
+		Buffer:   buffer,
+		CWD:      cwd,
+		Shell:    shell,
+		History:  historyStr,
+		Projects: ictx.FormatProjects(zones.FilterProjects(ictx.DetectProjects(cwd, repo)), suggest.MaxProjectTasks),
+		Git:      ictx.DetectGitBrief(cwd).String(),
+	}
+	for _, dir := range []string{cwd, files.PartialDir(buffer, cwd)} {
+		if dir == "" || zones.PrivateDir(dir) {
+			continue
+		}
+		if l, err := files.List(dir); err == nil {
+			in.Listings = append(in.Listings, l)
+		}
+	}
+
+	if budget, err := contextBudget(); err == nil {
+		budget.Fit([]ictx.Section{
+			{Name: "git", Text: &in.Git},
+			{Name: "project tasks", Text: &in.Projects},
+			{Name: "shell history", Text: &in.History},
+		})
+	}
+
+	suggestion, err := client.Complete(ctx, in)
 	if err != nil || suggestion == "" {
 		return nil
 	}
+	if _, missing := files.MissingPath(suggestion, len(buffer), cwd); missing || zones.PrivateCommand(suggestion) {
+		return nil
+	}
 
 	fmt.Println(suggestion)
 	return nil
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zeke-john/komplete/internal/toolchain"
+)
+
+var toolsRefresh bool
+
+var toolsCmd = &cobra.Command{
+	Use:   "tools",
+	Short: "Show the installed tools komplete tells the model about",
+	Long: `Show the package managers, container tools, version managers, languages
+and modern command line tools found on your PATH. The list is cached for a
+day, or until a directory on your PATH changes; --refresh takes stock again.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var inv toolchain.Inventory
+		if toolsRefresh {
+			inv = toolchain.Refresh(os.Getenv("PATH"))
+		} else {
+			inv = toolchain.Load(os.Getenv("PATH"))
+		}
+		if len(inv.Tools) == 0 && inv.OS == "" {
+			fmt.Fprintln(os.Stdout, "No tools found.")
+			return nil
+		}
+		fmt.Fprintln(os.Stdout, inv)
+		return nil
+	},
+}
+
+func init() {
+	toolsCmd.Flags().BoolVar(&toolsRefresh, "refresh", false, "take stock again instead of using the cached list")
+	rootCmd.AddCommand(toolsCmd)
+}
@@ -0,0 +1,228 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rescanInterval = 5 * time.Minute
+	probeTimeout   = 2 * time.Second
+)
+
+// builtins are names every supported shell understands without a binary on
+// PATH.
+var builtins = map[string]bool{
+	".": true, ":": true, "[": true, "[[": true, "alias": true, "autoload": true,
+	"bg": true, "bindkey": true, "builtin": true, "case": true, "cd": true,
+	"command": true, "declare": true, "dirs": true, "disown": true, "echo": true,
+	"eval": true, "exec": true, "exit": true, "export": true, "false": true,
+	"fc": true, "fg": true, "for": true, "function": true, "functions": true,
+	"hash": true, "history": true, "if": true, "jobs": true, "kill": true,
+	"let": true, "local": true, "noglob": true, "popd": true, "print": true,
+	"printf": true, "pushd": true, "pwd": true, "read": true, "rehash": true,
+	"return": true, "set": true, "setopt": true, "shift": true, "source": true,
+	"test": true, "time": true, "trap": true, "true": true, "type": true,
+	"typeset": true, "ulimit": true, "umask": true, "unalias": true,
+	"unset": true, "unsetopt": true, "until": true, "wait": true,
+	"whence": true, "which": true, "while": true,
+}
+
+// Resolver reports whether command names exist, caching the executables of
+// each PATH it has seen. Names that aren't on PATH are checked in the
+// background with the user's shell, so functions defined in its login files
+// are found without slowing down the request that first mentions them; until
+// the shell answers, such a name counts as missing.
+// Aliases and functions from interactive startup files are out of its
+// reach; the plugins tell the daemon about those.
+type Resolver struct {
+	shell string
+
+	mu    sync.Mutex
+	paths map[string]*pathSet
+}
+
+type pathSet struct {
+	scanned time.Time
+	names   map[string]bool
+	probed  map[string]bool
+	probing map[string]bool
+}
+
+func NewResolver(shell string) *Resolver {
+	return &Resolver{
+		shell: shell,
+		paths: make(map[string]*pathSet),
+	}
+}
+
+// Exists reports whether name runs as a command with the given PATH. Relative
+// and absolute paths are resolved against cwd. A name still being probed is
+// reported as missing; the answer is there for a later request.
+func (r *Resolver) Exists(name, pathEnv, cwd string) bool {
+	if name == "" {
+		return false
+	}
+	if builtins[name] {
+		return true
+	}
+	if strings.Contains(name, "/") {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(cwd, name)
+		}
+		info, err := os.Stat(name)
+		return err == nil && !info.IsDir() && info.Mode()&0o111 != 0
+	}
+	if pathEnv == "" {
+		pathEnv = os.Getenv("PATH")
+	}
+
+	r.mu.Lock()
+	set := r.paths[pathEnv]
+	r.mu.Unlock()
+	if set == nil || time.Since(set.scanned) > rescanInterval {
+		set = scanPath(pathEnv)
+		r.mu.Lock()
+		r.paths[pathEnv] = set
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if set.names[name] {
+		return true
+	}
+	// Installed since the scan.
+	if onPath(name, pathEnv) {
+		set.names[name] = true
+		return true
+	}
+	if found, ok := set.probed[name]; ok {
+		return found
+	}
+	if !set.probing[name] {
+		set.probing[name] = true
+		go r.probe(set, name, pathEnv, cwd)
+	}
+	return false
+}
+
+// onPath reports whether an executable file called name is in one of the
+// directories of pathEnv.
+func onPath(name, pathEnv string) bool {
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && !info.IsDir() && info.Mode()&0o111 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Resolver) probe(set *pathSet, name, pathEnv, cwd string) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	c := exec.CommandContext(ctx, r.shell, "-lc", "command -v -- "+Quote(name)+" >/dev/null 2>&1")
+	c.Dir = cwd
+	c.Env = append(os.Environ(), "PATH="+pathEnv)
+	found := c.Run() == nil
+
+	r.mu.Lock()
+	set.probed[name] = found
+	delete(set.probing, name)
+	r.mu.Unlock()
+}
+
+func scanPath(pathEnv string) *pathSet {
+	set := &pathSet{
+		scanned: time.Now(),
+		names:   make(map[string]bool),
+		probed:  make(map[string]bool),
+		probing: make(map[string]bool),
+	}
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				set.names[e.Name()] = true
+			}
+		}
+	}
+	return set
+}
+
+// Entrypoint returns the program a command line runs, skipping leading
+// environment assignments and the sudo and env wrappers.
+func Entrypoint(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	i := 0
+	for i < len(fields) && IsEnvAssignment(fields[i]) {
+		i++
+	}
+	if i >= len(fields) {
+		return ""
+	}
+
+	// Handle common wrappers.
+	if fields[i] == "sudo" || fields[i] == "env" {
+		i++
+		for i < len(fields) && IsEnvAssignment(fields[i]) {
+			i++
+		}
+		if i >= len(fields) {
+			return ""
+		}
+	}
+
+	return fields[i]
+}
+
+func IsEnvAssignment(token string) bool {
+	// Very small check: NAME=VALUE where NAME is a typical shell identifier.
+	if token == "" {
+		return false
+	}
+	eq := strings.IndexByte(token, '=')
+	if eq <= 0 {
+		return false
+	}
+	name := token[:eq]
+	for i, r := range name {
+		if i == 0 {
+			if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')) {
+				return false
+			}
+		} else {
+			if !(r == '_' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func Quote(value string) string {
+	if value == "" {
+		return "''"
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
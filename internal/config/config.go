@@ -2,10 +2,12 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"syscall"
 )
 
 type Config map[string]string
@@ -22,6 +24,46 @@ func ConfigPath() (string, error) {
 	return filepath.Join(home, ".config", "komplete", "config.toml"), nil
 }
 
+// StateDir is where komplete keeps runtime state such as pause markers.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "komplete"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "state", "komplete"), nil
+}
+
+// RuntimeDir is where the daemon keeps its socket. It is created with mode
+// 0700 and must be owned by the current user, so other users can neither
+// reach the daemon nor plant files in it.
+func RuntimeDir() (string, error) {
+	var dir string
+	if base := os.Getenv("XDG_RUNTIME_DIR"); base != "" {
+		dir = filepath.Join(base, "komplete")
+	} else {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("komplete-%d", os.Getuid()))
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0o700 || !ownedByUser(info) {
+		return "", fmt.Errorf("%s must be a directory owned by you with mode 0700", dir)
+	}
+	return dir, nil
+}
+
+func ownedByUser(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}
+
 func Load(path string) (Config, error) {
 	cfg := Config{}
 	data, err := os.ReadFile(path)
@@ -82,7 +124,7 @@ func Save(path string, cfg Config) error {
 }
 
 func AllowedKeys() []string {
-	return []string{"model", "shell", "timeout", "cwd", "groq_model", "groq_api_key", "openrouter_api_key"}
+	return []string{"model", "shell", "timeout", "cwd", "groq_model", "groq_api_key", "openrouter_api_key", "private_dirs", "private_commands", "cache_size", "cache_ttl", "disk_cache_size", "disk_cache_ttl", "context_budget"}
 }
 
 var envKeyMap = map[string]string{
@@ -90,6 +132,10 @@ var envKeyMap = map[string]string{
 	"openrouter_api_key": "OPENROUTER_API_KEY",
 }
 
+// setFromConfig records the variables LoadAPIKeysIntoEnv set, so APIKey can
+// tell them apart from ones the process was started with.
+var setFromConfig = map[string]bool{}
+
 func LoadAPIKeysIntoEnv() {
 	path, err := ConfigPath()
 	if err != nil {
@@ -105,10 +151,21 @@ func LoadAPIKeysIntoEnv() {
 		}
 		if val := cfg[cfgKey]; val != "" {
 			os.Setenv(envVar, val)
+			setFromConfig[envVar] = true
 		}
 	}
 }
 
+// APIKey returns the key for cfgKey, preferring the environment the process
+// was started with over cfg, for long-running processes that reload cfg.
+func APIKey(cfg Config, cfgKey string) string {
+	envVar := envKeyMap[cfgKey]
+	if val := os.Getenv(envVar); val != "" && !setFromConfig[envVar] {
+		return val
+	}
+	return cfg[cfgKey]
+}
+
 func IsAllowedKey(key string) bool {
 	for _, k := range AllowedKeys() {
 		if k == key {
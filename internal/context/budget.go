@@ -0,0 +1,122 @@
+package context
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultBudgetTokens is how much context a request sends unless the
+	// user picks another budget.
+	DefaultBudgetTokens = 4000
+	// MaxBudgetBytes caps the context whatever the token budget, since
+	// text full of non-ASCII runs to more bytes per token.
+	MaxBudgetBytes = 64 << 10
+	// bytesPerToken is a rough average for the code and paths we send.
+	bytesPerToken = 4
+)
+
+// Budget bounds how much context goes into one prompt.
+type Budget struct {
+	Tokens int
+	Bytes  int
+}
+
+func (b Budget) limit() int {
+	limit := b.Bytes
+	if b.Tokens > 0 && (limit <= 0 || b.Tokens*bytesPerToken < limit) {
+		limit = b.Tokens * bytesPerToken
+	}
+	return limit
+}
+
+// Section is one source of context that Fit may cut.
+type Section struct {
+	Name string
+	Text *string
+}
+
+// Fit cuts sections so that together they fit the budget and returns a
+// note for each one it cut. Sections that fit in an even share of the
+// budget are left whole, and what they don't use goes to the others.
+// Text is cut at line ends, keeping the start, which is where each source
+// puts what matters most.
+func (b Budget) Fit(sections []Section) []string {
+	limit := b.limit()
+	total := 0
+	for _, s := range sections {
+		total += len(*s.Text)
+	}
+	if limit <= 0 || total <= limit {
+		return nil
+	}
+
+	// Share the budget out smallest section first.
+	order := make([]int, len(sections))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return len(*sections[a].Text) - len(*sections[b].Text)
+	})
+
+	var notes []string
+	left := limit
+	for n, i := range order {
+		s := sections[i]
+		share := left / (len(order) - n)
+		if len(*s.Text) > share {
+			kept, lines, all := cutLines(*s.Text, share)
+			notes = append(notes, fmt.Sprintf("%s: kept %d of %d lines (%d of %d bytes) to fit the context budget", s.Name, lines, all, len(kept), len(*s.Text)))
+			*s.Text = kept
+		}
+		left -= len(*s.Text)
+	}
+	return notes
+}
+
+// cutLines keeps the whole lines of text that fit in max bytes, with a line
+// saying how many were left out, and returns what it kept and how many of
+// the lines. It keeps nothing when max can't even hold that line.
+func cutLines(text string, max int) (string, int, int) {
+	lines := strings.Split(text, "\n")
+	// Leaving out fewer lines never makes the note longer.
+	room := max - len(moreLines(len(lines)))
+	if room < 0 {
+		return "", 0, len(lines)
+	}
+	var b strings.Builder
+	kept := 0
+	for _, line := range lines {
+		if b.Len()+len(line)+1 > room {
+			break
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+		kept++
+	}
+	b.WriteString(moreLines(len(lines) - kept))
+	return b.String(), kept, len(lines)
+}
+
+func moreLines(n int) string {
+	return fmt.Sprintf("(+%d more lines)", n)
+}
+
+// Within runs collect, giving up after timeout. A collector that overruns
+// keeps going in the background, but nothing waits for it.
+func Within[T any](timeout time.Duration, collect func() T) (T, bool) {
+	done := make(chan T, 1)
+	go func() { done <- collect() }()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case v := <-done:
+		return v, true
+	case <-timer.C:
+		var zero T
+		return zero, false
+	}
+}
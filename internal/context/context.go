@@ -2,10 +2,14 @@ package context
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 )
 
 type Context struct {
@@ -14,8 +18,16 @@ type Context struct {
 	CWD       string
 	RepoRoot  string
 	GitStatus string
+	Git       Git
+	Projects  []Project
+	// Notes say what was summarized or left out, for --verbose.
+	Notes []string
 }
 
+// projectsTimeout bounds the look for project tasks, which reads a few
+// files in each directory up to the repo root.
+const projectsTimeout = 500 * time.Millisecond
+
 func BuildContext(shellOverride string, cwdOverride string) (Context, error) {
 	ctx := Context{
 		OS: runtime.GOOS,
@@ -40,34 +52,34 @@ func BuildContext(shellOverride string, cwdOverride string) (Context, error) {
 	}
 	ctx.CWD = cwd
 
-	repoRoot, gitStatus := detectGit(cwd)
-	ctx.RepoRoot = repoRoot
-	ctx.GitStatus = gitStatus
+	ctx.Git = DetectGit(cwd)
+	ctx.RepoRoot = ctx.Git.Root
+	ctx.GitStatus = ctx.Git.Status
+	ctx.Notes = append(ctx.Notes, ctx.Git.Notes...)
+	projects, ok := Within(projectsTimeout, func() []Project { return DetectProjects(cwd, ctx.RepoRoot) })
+	if !ok {
+		ctx.Notes = append(ctx.Notes, fmt.Sprintf("project tasks: looking took longer than %s", projectsTimeout))
+	}
+	ctx.Projects = projects
 
 	return ctx, nil
 }
 
-func detectGit(cwd string) (string, string) {
-	repoRoot, err := runGit(cwd, "rev-parse", "--show-toplevel")
-	if err != nil || repoRoot == "" {
-		return "", ""
-	}
-
-	status, err := runGit(cwd, "status", "--porcelain", "-b")
-	if err != nil {
-		return repoRoot, ""
-	}
-
-	return repoRoot, status
-}
+var errTimeout = errors.New("timed out")
 
-func runGit(cwd string, args ...string) (string, error) {
-	cmd := exec.Command("git", args...)
+// runGit runs git in cwd, killing it after timeout.
+func runGit(cwd string, timeout time.Duration, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
 	cmd.Dir = cwd
 	var out bytes.Buffer
 	cmd.Stdout = &out
 	cmd.Stderr = &out
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", errTimeout
+		}
 		return "", err
 	}
 	return strings.TrimSpace(out.String()), nil
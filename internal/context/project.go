@@ -0,0 +1,502 @@
+package context
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxProjectDirs bounds how far above cwd we look for projects.
+	maxProjectDirs = 8
+	maxDetail      = 60
+	projectTTL     = 30 * time.Second
+	maxCachedDirs  = 256
+)
+
+// Task is something a project knows how to run: a command typed in the
+// project's directory, and what it does when the project says.
+type Task struct {
+	Command string
+	Detail  string
+}
+
+// Project is one kind of project found in Dir, such as an npm package or a
+// Makefile, with the tasks it defines.
+type Project struct {
+	Dir  string
+	Kind string
+	Name string
+	// Note is anything else worth knowing, like a workspace's members.
+	Note  string
+	Tasks []Task
+}
+
+type projectProvider struct {
+	// files are the names the provider looks for, in order of preference.
+	files  []string
+	detect func(dir string, data []byte) (Project, bool)
+}
+
+var projectProviders = []projectProvider{
+	{[]string{"package.json"}, detectNPM},
+	{[]string{"Makefile", "makefile", "GNUmakefile"}, detectMake},
+	{[]string{"justfile", "Justfile", ".justfile"}, detectJust},
+	{[]string{"go.mod"}, detectGo},
+	{[]string{"Cargo.toml"}, detectCargo},
+	{[]string{"pyproject.toml"}, detectPython},
+	{[]string{"compose.yaml", "compose.yml", "docker-compose.yml", "docker-compose.yaml"}, detectCompose},
+}
+
+// DetectProjects finds the projects in cwd and every directory above it up
+// to repoRoot, nearest first. Outside a repo only cwd is looked at.
+func DetectProjects(cwd, repoRoot string) []Project {
+	var projects []Project
+	for _, dir := range projectDirs(cwd, repoRoot) {
+		projects = append(projects, projectsIn(dir)...)
+	}
+	return projects
+}
+
+func projectDirs(cwd, repoRoot string) []string {
+	dirs := []string{cwd}
+	if repoRoot == "" || (cwd != repoRoot && !strings.HasPrefix(cwd, repoRoot+string(filepath.Separator))) {
+		return dirs
+	}
+	for dir := cwd; dir != repoRoot && len(dirs) < maxProjectDirs; {
+		dir = filepath.Dir(dir)
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func projectsIn(dir string) []Project {
+	var projects []Project
+	for _, p := range projectProviders {
+		for _, name := range p.files {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+			if project, ok := p.detect(dir, data); ok {
+				project.Dir = dir
+				projects = append(projects, project)
+			}
+			break
+		}
+	}
+	return projects
+}
+
+// FormatProjects describes projects for a prompt, one line per project
+// followed by its tasks, listing at most maxTasks tasks in all.
+func FormatProjects(projects []Project, maxTasks int) string {
+	var b strings.Builder
+	left, skipped := maxTasks, 0
+	for _, p := range projects {
+		if left == 0 {
+			skipped += len(p.Tasks)
+			continue
+		}
+		b.WriteString(p.Dir)
+		b.WriteString(": ")
+		b.WriteString(p.Kind)
+		if p.Name != "" {
+			b.WriteString(" " + strconv.Quote(p.Name))
+		}
+		if p.Note != "" {
+			b.WriteString(" (" + p.Note + ")")
+		}
+		b.WriteByte('\n')
+		for i, t := range p.Tasks {
+			if left == 0 {
+				skipped += len(p.Tasks) - i
+				break
+			}
+			left--
+			b.WriteString("  " + t.Command)
+			if t.Detail != "" {
+				b.WriteString("  # " + t.Detail)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	if skipped > 0 {
+		b.WriteString("(+" + strconv.Itoa(skipped) + " more tasks)")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func detail(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > maxDetail {
+		s = s[:maxDetail-3] + "..."
+	}
+	return s
+}
+
+func detectNPM(dir string, data []byte) (Project, bool) {
+	var pkg struct {
+		Name           string            `json:"name"`
+		Scripts        map[string]string `json:"scripts"`
+		PackageManager string            `json:"packageManager"`
+		Workspaces     json.RawMessage   `json:"workspaces"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return Project{}, false
+	}
+
+	pm := "npm"
+	if name, _, ok := strings.Cut(pkg.PackageManager, "@"); ok && name != "" {
+		pm = name
+	} else {
+		for _, lock := range []struct{ file, pm string }{
+			{"pnpm-lock.yaml", "pnpm"},
+			{"yarn.lock", "yarn"},
+			{"bun.lock", "bun"},
+			{"bun.lockb", "bun"},
+		} {
+			if _, err := os.Stat(filepath.Join(dir, lock.file)); err == nil {
+				pm = lock.pm
+				break
+			}
+		}
+	}
+
+	p := Project{Kind: pm + " package", Name: pkg.Name}
+	if len(pkg.Workspaces) > 0 {
+		p.Note = "workspace root"
+	}
+	names := make([]string, 0, len(pkg.Scripts))
+	for name := range pkg.Scripts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		p.Tasks = append(p.Tasks, Task{Command: pm + " run " + name, Detail: detail(pkg.Scripts[name])})
+	}
+	return p, true
+}
+
+func detectMake(dir string, data []byte) (Project, bool) {
+	p := Project{Kind: "make"}
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || line[0] == '\t' || line[0] == ' ' || line[0] == '#' || line[0] == '.' {
+			continue
+		}
+		targets, rest, ok := strings.Cut(line, ":")
+		if !ok || strings.ContainsAny(targets, "=$%") || strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":=") {
+			continue
+		}
+		var help string
+		if _, h, ok := strings.Cut(rest, "##"); ok {
+			help = detail(h)
+		}
+		for _, t := range strings.Fields(targets) {
+			if seen[t] || strings.Contains(t, "/") {
+				continue
+			}
+			seen[t] = true
+			p.Tasks = append(p.Tasks, Task{Command: "make " + t, Detail: help})
+		}
+	}
+	return p, len(p.Tasks) > 0
+}
+
+func detectJust(dir string, data []byte) (Project, bool) {
+	p := Project{Kind: "just"}
+	var comment string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "#") {
+			comment = detail(strings.TrimLeft(line, "# "))
+			continue
+		}
+		doc := comment
+		comment = ""
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '[' {
+			continue
+		}
+		head, _, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line[len(head):], ":=") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(head, "@"))
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "_") {
+			continue
+		}
+		switch fields[0] {
+		case "set", "alias", "export", "import", "mod":
+			continue
+		}
+		command := "just " + fields[0]
+		for _, param := range fields[1:] {
+			// Parameters with defaults can be left out.
+			if !strings.Contains(param, "=") {
+				command += " " + strings.ToUpper(strings.TrimLeft(param, "+*$"))
+			}
+		}
+		p.Tasks = append(p.Tasks, Task{Command: command, Detail: doc})
+	}
+	return p, len(p.Tasks) > 0
+}
+
+func detectGo(dir string, data []byte) (Project, bool) {
+	p := Project{Kind: "go module"}
+	for _, line := range strings.Split(string(data), "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+			p.Name = strings.Trim(strings.TrimSpace(rest), `"`)
+			break
+		}
+	}
+	p.Tasks = []Task{{Command: "go build ./..."}, {Command: "go test ./..."}}
+	if mainPackage(dir) {
+		p.Tasks = append(p.Tasks, Task{Command: "go run ."})
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "cmd"))
+	for _, e := range entries {
+		if e.IsDir() && mainPackage(filepath.Join(dir, "cmd", e.Name())) {
+			p.Tasks = append(p.Tasks, Task{Command: "go run ./cmd/" + e.Name()})
+		}
+	}
+	return p, true
+}
+
+// mainPackage reports whether the Go package in dir is a command.
+func mainPackage(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		pkg := ""
+		for scanner.Scan() {
+			if rest, ok := strings.CutPrefix(scanner.Text(), "package "); ok {
+				pkg = strings.TrimSpace(rest)
+				break
+			}
+		}
+		file.Close()
+		if pkg != "" {
+			return pkg == "main"
+		}
+	}
+	return false
+}
+
+func detectCargo(dir string, data []byte) (Project, bool) {
+	p := Project{Kind: "cargo"}
+	var members []string
+	for _, kv := range parseTOML(data) {
+		switch {
+		case kv.section == "package" && kv.key == "name":
+			p.Name = kv.value
+		case kv.section == "workspace" && kv.key == "members":
+			members = tomlArray(kv.value)
+		}
+	}
+	if len(members) > 0 {
+		p.Kind = "cargo workspace"
+		p.Note = "members " + strings.Join(members, ", ")
+		p.Tasks = []Task{{Command: "cargo build --workspace"}, {Command: "cargo test --workspace"}}
+		return p, true
+	}
+	p.Tasks = []Task{{Command: "cargo build"}, {Command: "cargo test"}}
+	if _, err := os.Stat(filepath.Join(dir, "src", "main.rs")); err == nil {
+		p.Tasks = append(p.Tasks, Task{Command: "cargo run"})
+	}
+	return p, true
+}
+
+func detectPython(dir string, data []byte) (Project, bool) {
+	p := Project{Kind: "python project"}
+	pytest := false
+	for _, kv := range parseTOML(data) {
+		switch kv.section {
+		case "project", "tool.poetry":
+			if kv.key == "name" && p.Name == "" {
+				p.Name = kv.value
+			}
+		case "project.scripts":
+			p.Tasks = append(p.Tasks, Task{Command: kv.key, Detail: detail(kv.value)})
+		case "tool.poetry.scripts":
+			p.Tasks = append(p.Tasks, Task{Command: "poetry run " + kv.key, Detail: detail(kv.value)})
+		case "tool.pdm.scripts":
+			p.Tasks = append(p.Tasks, Task{Command: "pdm run " + kv.key, Detail: detail(kv.value)})
+		case "tool.poe.tasks":
+			p.Tasks = append(p.Tasks, Task{Command: "poe " + kv.key, Detail: detail(kv.value)})
+		case "tool.pytest.ini_options":
+			pytest = true
+		}
+	}
+	if pytest {
+		p.Tasks = append(p.Tasks, Task{Command: "pytest"})
+	}
+	return p, true
+}
+
+func detectCompose(dir string, data []byte) (Project, bool) {
+	p := Project{Kind: "docker compose"}
+	inServices := false
+	indent := -1
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		depth := len(line) - len(strings.TrimLeft(line, " "))
+		if depth == 0 {
+			inServices = strings.HasPrefix(line, "services:")
+			continue
+		}
+		if !inServices {
+			continue
+		}
+		if indent == -1 {
+			indent = depth
+		}
+		if depth != indent {
+			continue
+		}
+		if name, ok := strings.CutSuffix(strings.SplitN(trimmed, " #", 2)[0], ":"); ok {
+			name = strings.Trim(name, `"'`)
+			p.Tasks = append(p.Tasks, Task{Command: "docker compose up " + name})
+		}
+	}
+	return p, len(p.Tasks) > 0
+}
+
+type tomlValue struct {
+	section, key, value string
+}
+
+// parseTOML reads the key = value pairs of a TOML file with their table,
+// which is all we need from project manifests. Quoted strings are unquoted;
+// arrays and inline tables are kept as written, joined onto one line.
+func parseTOML(data []byte) []tomlValue {
+	var values []tomlValue
+	section := ""
+	lines := strings.Split(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			section = strings.Trim(strings.SplitN(line, "#", 2)[0], "[] \t")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		// Arrays may span lines.
+		for depth := bracketDepth(value); depth > 0 && i+1 < len(lines); depth = bracketDepth(value) {
+			i++
+			value += " " + strings.TrimSpace(lines[i])
+		}
+		values = append(values, tomlValue{
+			section: section,
+			key:     strings.Trim(strings.TrimSpace(key), `"'`),
+			value:   tomlString(value),
+		})
+	}
+	return values
+}
+
+func bracketDepth(s string) int {
+	return strings.Count(s, "[") - strings.Count(s, "]")
+}
+
+func tomlString(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		if end := strings.Index(value[1:], `"`); end >= 0 {
+			if s, err := strconv.Unquote(value[:end+2]); err == nil {
+				return s
+			}
+		}
+	}
+	if strings.HasPrefix(value, "'") {
+		if end := strings.Index(value[1:], "'"); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	return value
+}
+
+// tomlArray returns the strings in a one-line TOML array.
+func tomlArray(value string) []string {
+	value = strings.Trim(value, "[] ")
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.Trim(strings.TrimSpace(item), `"'`); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ProjectCache remembers the projects found in each directory, looking
+// again when the directory changes or its entry gets old, so edits to a
+// manifest show up within projectTTL.
+type ProjectCache struct {
+	mu      sync.Mutex
+	entries map[string]projectEntry
+}
+
+type projectEntry struct {
+	modTime  time.Time
+	checked  time.Time
+	projects []Project
+}
+
+func NewProjectCache() *ProjectCache {
+	return &ProjectCache{entries: make(map[string]projectEntry)}
+}
+
+// Detect is DetectProjects with each directory's projects cached.
+func (c *ProjectCache) Detect(cwd, repoRoot string) []Project {
+	var projects []Project
+	for _, dir := range projectDirs(cwd, repoRoot) {
+		projects = append(projects, c.projectsIn(dir)...)
+	}
+	return projects
+}
+
+func (c *ProjectCache) projectsIn(dir string) []Project {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[dir]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) && time.Since(entry.checked) < projectTTL {
+		return entry.projects
+	}
+
+	projects := projectsIn(dir)
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedDirs {
+		c.entries = make(map[string]projectEntry)
+	}
+	c.entries[dir] = projectEntry{modTime: info.ModTime(), checked: time.Now(), projects: projects}
+	c.mu.Unlock()
+	return projects
+}
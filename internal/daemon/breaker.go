@@ -0,0 +1,122 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zeke-john/komplete/internal/suggest"
+)
+
+const (
+	// The breaker opens after breakerThreshold failures in a row, for
+	// breakerMinCooldown at first and doubling up to breakerMaxCooldown
+	// while trial requests keep failing.
+	breakerThreshold   = 5
+	breakerMinCooldown = 5 * time.Second
+	breakerMaxCooldown = 2 * time.Minute
+)
+
+// errBackoff is returned instead of calling a provider that is rate limiting
+// us or failing.
+type errBackoff struct {
+	until time.Time
+}
+
+func (e *errBackoff) Error() string {
+	return fmt.Sprintf("provider backing off for %s", time.Until(e.until).Round(time.Second))
+}
+
+// breaker stops calls to a provider that keeps failing or asked us to slow
+// down. Once the wait is over it lets one trial call through, and closes
+// again if that succeeds.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	cooldown  time.Duration
+	openUntil time.Time
+	probing   bool
+	// probeUntil is the deadline of the trial call in flight, the longest
+	// other calls have to wait to learn how it went.
+	probeUntil time.Time
+}
+
+// allow reports whether a call may go ahead, or until when it may not. A
+// trial call gives up by ctx's deadline, or requestTimeout without one.
+func (b *breaker) allow(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return &errBackoff{until: b.openUntil}
+	}
+	if b.failures >= breakerThreshold {
+		if b.probing {
+			return &errBackoff{until: b.probeUntil}
+		}
+		b.probing = true
+		b.probeUntil = now.Add(requestTimeout)
+		if deadline, ok := ctx.Deadline(); ok {
+			b.probeUntil = deadline
+		}
+	}
+	return nil
+}
+
+// done records the outcome of a call allow let through.
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+
+	var apiErr *suggest.APIError
+	switch {
+	case err == nil:
+		b.failures = 0
+		b.cooldown = 0
+	case errors.Is(err, context.Canceled):
+		// The user moved on; says nothing about the provider.
+	case errors.As(err, &apiErr) && apiErr.RateLimited():
+		b.openUntil = later(b.openUntil, time.Now().Add(max(apiErr.RetryAfter, time.Second)))
+	case errors.As(err, &apiErr) && !apiErr.Temporary():
+		// A bad request or key won't improve by waiting.
+	default:
+		b.failures++
+		if b.failures >= breakerThreshold {
+			b.cooldown = min(max(2*b.cooldown, breakerMinCooldown), breakerMaxCooldown)
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+	}
+}
+
+// until returns when the breaker lets calls through again, or zero if it
+// does now.
+func (b *breaker) until() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return b.openUntil
+	}
+	if b.probing {
+		return b.probeUntil
+	}
+	return time.Time{}
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.cooldown = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSize = 512
+	defaultCacheTTL  = time.Minute
+)
+
+type cacheEntry struct {
+	key        string
+	suggestion string
+	timestamp  time.Time
+}
+
+// suggestionCache is an LRU of suggestions keyed by cwd and buffer. Lookups
+// also match an entry for a shorter buffer whose suggestion the current
+// buffer still continues, so typing along a suggestion needs no new one.
+type suggestionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // most recently used at the front
+	entries map[string]*list.Element
+}
+
+func newSuggestionCache(size int, ttl time.Duration) *suggestionCache {
+	return &suggestionCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func cacheKey(cwd, buffer string) string {
+	return cwd + "\x00" + buffer
+}
+
+// get returns the cached suggestion for buffer, or for the longest shorter
+// buffer whose suggestion extends this one.
+func (c *suggestionCache) get(cwd, buffer string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n := len(buffer); n > 0; n-- {
+		el, ok := c.entries[cacheKey(cwd, buffer[:n])]
+		if !ok {
+			continue
+		}
+		e := el.Value.(*cacheEntry)
+		if time.Since(e.timestamp) > c.ttl {
+			c.remove(el)
+			continue
+		}
+		if n < len(buffer) && (len(e.suggestion) <= len(buffer) || !strings.HasPrefix(e.suggestion, buffer)) {
+			continue
+		}
+		c.order.MoveToFront(el)
+		return e.suggestion, true
+	}
+	return "", false
+}
+
+func (c *suggestionCache) put(cwd, buffer, suggestion string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(cwd, buffer)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		e.suggestion = suggestion
+		e.timestamp = time.Now()
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, suggestion: suggestion, timestamp: time.Now()})
+	c.evict()
+}
+
+// configure applies new limits, evicting entries over the new size.
+func (c *suggestionCache) configure(size int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.ttl = ttl
+	c.evict()
+}
+
+func (c *suggestionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	clear(c.entries)
+}
+
+// evict must be called with mu held.
+func (c *suggestionCache) evict() {
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *suggestionCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// snapshot returns the live entries, most recently used first.
+func (c *suggestionCache) snapshot() []cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]cacheEntry, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*cacheEntry)
+		if time.Since(e.timestamp) <= c.ttl {
+			entries = append(entries, *e)
+		}
+	}
+	return entries
+}
+
+// restore adds entries saved elsewhere, most recently used first, behind
+// the entries already here. Where both have an entry, the newer one wins.
+func (c *suggestionCache) restore(entries []cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		if time.Since(e.timestamp) > c.ttl {
+			continue
+		}
+		if el, ok := c.entries[e.key]; ok {
+			if cur := el.Value.(*cacheEntry); e.timestamp.After(cur.timestamp) {
+				cur.suggestion = e.suggestion
+				cur.timestamp = e.timestamp
+			}
+			continue
+		}
+		c.entries[e.key] = c.order.PushBack(&e)
+	}
+	c.evict()
+}
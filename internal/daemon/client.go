@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Client speaks the daemon protocol over one connection. Send and Recv may
+// run in different goroutines, but neither is safe to call concurrently with
+// itself.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	enc     *json.Encoder
+	nextID  uint64
+}
+
+func Dial(socketPath string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("unix", socketPath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	enc := json.NewEncoder(conn)
+	enc.SetEscapeHTML(false)
+	return &Client{conn: conn, scanner: scanner, enc: enc}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// SetDeadline bounds reads and writes on the connection, as with
+// net.Conn.SetDeadline.
+func (c *Client) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Send writes req with the protocol version and, when req.ID is zero, a fresh
+// ID, which it returns.
+func (c *Client) Send(req Request) (uint64, error) {
+	req.Version = ProtocolVersion
+	if req.ID == 0 {
+		c.nextID++
+		req.ID = c.nextID
+	}
+	return req.ID, c.enc.Encode(req)
+}
+
+// Recv reads the next response for any request.
+func (c *Client) Recv() (Response, error) {
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return Response{}, err
+		}
+		return Response{}, fmt.Errorf("daemon closed the connection")
+	}
+	var resp Response
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return Response{}, err
+	}
+	return resp, nil
+}
+
+// Call sends req and waits up to timeout for its final response, skipping
+// interim responses and responses to other requests.
+func (c *Client) Call(req Request, timeout time.Duration) (Response, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	id, err := c.Send(req)
+	if err != nil {
+		return Response{}, err
+	}
+	for {
+		resp, err := c.Recv()
+		if err != nil {
+			return Response{}, err
+		}
+		if resp.ID != id || !resp.Final {
+			continue
+		}
+		if resp.Error != nil {
+			return resp, fmt.Errorf("%s: %s", resp.Error.Code, resp.Error.Message)
+		}
+		return resp, nil
+	}
+}
@@ -4,57 +4,146 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/zeke-john/komplete/internal/commands"
 	"github.com/zeke-john/komplete/internal/config"
+	ictx "github.com/zeke-john/komplete/internal/context"
+	"github.com/zeke-john/komplete/internal/files"
+	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/privacy"
 	"github.com/zeke-john/komplete/internal/suggest"
 )
 
-type Request struct {
-	Buffer string `json:"buffer"`
-	CWD    string `json:"cwd"`
-	Shell  string `json:"shell"`
-}
-
-type cacheEntry struct {
-	suggestion string
-	timestamp  time.Time
-}
-
 type Server struct {
-	listener     net.Listener
-	client       *suggest.Client
-	historyCache *HistoryCache
-	portFile     string
-
-	mu    sync.RWMutex
-	cache map[string]cacheEntry
+	listener   net.Listener
+	shell      string
+	sessions   *sessionStore
+	listings   *files.Cache
+	projects   *ictx.ProjectCache
+	repos      *ictx.GitCache
+	commands   *commands.Resolver
+	log        *log.Logger
+	opts       Options
+	started    time.Time
+	clientOpts []suggest.Option
+	metrics    *metrics
+	feedback   *feedbackStore
+	ran        *history.Store
+	flights    *flightGroup
+
+	breakerMu sync.Mutex
+	breakers  map[string]*breaker
+
+	settings atomic.Pointer[settings]
+	reloadMu sync.Mutex
+
+	cache *suggestionCache
+	disk  atomic.Pointer[diskCache]
+
+	histMu    sync.Mutex
+	histories map[string]*HistoryCache
+
+	connMu     sync.Mutex
+	conns      map[*serverConn]struct{}
+	lastActive time.Time
+	connWG     sync.WaitGroup
+
+	shutdownOnce sync.Once
+	done         chan struct{}
 }
 
 const (
-	cacheMaxEntries = 128
-	cacheTTL        = 60 * time.Second
-	historyRefresh  = 30 * time.Second
 	requestTimeout  = 3 * time.Second
+	maxRequestSize  = 1 << 20
+	drainTimeout    = 5 * time.Second
+	persistInterval = time.Minute
 )
 
-func NewServer(portFile string) (*Server, error) {
-	config.LoadAPIKeysIntoEnv()
+// DefaultIdleTimeout is how long a daemon with no clients waits before
+// exiting, so one orphaned by its shells doesn't live forever.
+const DefaultIdleTimeout = time.Hour
+
+// Options configures a Server.
+type Options struct {
+	SocketPath string
+	// PIDFile defaults to PIDFilePath(SocketPath).
+	PIDFile string
+	// Log receives the daemon's log; nil discards it.
+	Log io.Writer
+	// IdleTimeout of zero never exits for idleness.
+	IdleTimeout time.Duration
+	Version     string
+	// StatsFile defaults to StatsPath(), FeedbackFile to FeedbackPath() and
+	// CommandsFile to history.StorePath().
+	StatsFile    string
+	FeedbackFile string
+	CommandsFile string
+	// ShowRedactions logs every secret masked from a request.
+	ShowRedactions bool
+}
+
+// PIDFilePath is the pidfile that sits next to a daemon socket.
+func PIDFilePath(socketPath string) string {
+	return strings.TrimSuffix(socketPath, filepath.Ext(socketPath)) + ".pid"
+}
 
-	apiKey := os.Getenv("GROQ_API_KEY")
-	if apiKey == "" {
-		return nil, fmt.Errorf("GROQ_API_KEY not set")
+// ReadPID returns the PID recorded in a pidfile.
+func ReadPID(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
 	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
 
-	model := loadGroqModel()
+func NewServer(opts Options) (*Server, error) {
+	config.LoadAPIKeysIntoEnv()
 
+	if opts.Log == nil {
+		opts.Log = io.Discard
+	}
+	if opts.PIDFile == "" {
+		opts.PIDFile = PIDFilePath(opts.SocketPath)
+	}
+	if opts.StatsFile == "" {
+		path, err := StatsPath()
+		if err != nil {
+			return nil, err
+		}
+		opts.StatsFile = path
+	}
+	if opts.FeedbackFile == "" {
+		path, err := FeedbackPath()
+		if err != nil {
+			return nil, err
+		}
+		opts.FeedbackFile = path
+	}
+	if opts.CommandsFile == "" {
+		path, err := history.StorePath()
+		if err != nil {
+			return nil, err
+		}
+		opts.CommandsFile = path
+	}
+
+	// One HTTP client is shared across reloads so connections to the
+	// provider stay warm.
 	httpClient := &http.Client{
 		Timeout: requestTimeout,
 		Transport: &http.Transport{
@@ -64,26 +153,58 @@ func NewServer(portFile string) (*Server, error) {
 		},
 	}
 
-	suggestClient := suggest.NewClient(apiKey, model, suggest.WithHTTPClient(httpClient))
+	clientOpts := []suggest.Option{suggest.WithHTTPClient(httpClient)}
+	if opts.ShowRedactions {
+		clientOpts = append(clientOpts, suggest.WithRedactionLog(opts.Log))
+	}
 
 	shell := os.Getenv("SHELL")
 	if shell == "" {
 		shell = "zsh"
 	}
 
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s := &Server{
+		shell:      shell,
+		sessions:   newSessionStore(),
+		histories:  make(map[string]*HistoryCache),
+		listings:   files.NewCache(),
+		projects:   ictx.NewProjectCache(),
+		repos:      ictx.NewGitCache(),
+		commands:   commands.NewResolver(shell),
+		log:        log.New(opts.Log, "", log.LstdFlags),
+		opts:       opts,
+		started:    time.Now(),
+		clientOpts: clientOpts,
+		metrics:    newMetrics(opts.StatsFile),
+		flights:    newFlightGroup(),
+		breakers:   make(map[string]*breaker),
+		feedback:   newFeedbackStore(opts.FeedbackFile),
+		ran:        history.NewStore(opts.CommandsFile),
+		conns:      make(map[*serverConn]struct{}),
+		lastActive: time.Now(),
+		done:       make(chan struct{}),
+	}
+
+	st, err := s.loadSettings()
 	if err != nil {
-		return nil, fmt.Errorf("listen: %w", err)
+		return nil, err
 	}
+	s.settings.Store(st)
+	s.cache = newSuggestionCache(st.cacheSize, st.cacheTTL)
+	s.configureDiskCache(st)
 
-	s := &Server{
-		listener:     listener,
-		client:       suggestClient,
-		historyCache: NewHistoryCache(shell, historyRefresh),
-		portFile:     portFile,
-		cache:        make(map[string]cacheEntry),
+	listener, err := listenUnix(opts.SocketPath)
+	if err != nil {
+		return nil, err
 	}
+	s.listener = listener
 
+	if err := os.WriteFile(opts.PIDFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s.history(shell, "")
 	return s, nil
 }
 
@@ -91,119 +212,617 @@ func (s *Server) Addr() net.Addr {
 	return s.listener.Addr()
 }
 
+// Run serves until Shutdown, a SIGTERM or SIGINT, or the idle timeout, and
+// returns once in-flight requests have drained. SIGHUP reloads the config,
+// as does any change to config.toml.
 func (s *Server) Run() error {
-	addr := s.listener.Addr().(*net.TCPAddr)
-	if err := os.WriteFile(s.portFile, []byte(fmt.Sprintf("%d", addr.Port)), 0o644); err != nil {
-		s.listener.Close()
-		return fmt.Errorf("write port file: %w", err)
-	}
-
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
 	go func() {
-		<-sigCh
-		s.Shutdown()
+		for {
+			select {
+			case sig := <-sigCh:
+				if sig == syscall.SIGHUP {
+					s.reload()
+					continue
+				}
+				s.log.Printf("received %s", sig)
+				s.Shutdown()
+				return
+			case <-s.done:
+				return
+			}
+		}
 	}()
+	go s.watchConfig()
+	go s.persistLoop()
 
+	if s.opts.IdleTimeout > 0 {
+		go s.watchIdle()
+	}
+
+	s.log.Printf("komplete daemon %s (pid %d) listening on %s, model %s", s.opts.Version, os.Getpid(), s.listener.Addr(), s.settings.Load().client.Model())
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
-			return nil
+			break
+		}
+		if !samePeerUser(conn) {
+			s.log.Printf("rejected connection from another user")
+			conn.Close()
+			continue
 		}
-		go s.handleConn(conn)
+		s.serve(conn)
 	}
+
+	<-s.done
+	return nil
 }
 
+// Shutdown stops accepting connections, lets requests already in flight
+// answer for up to drainTimeout, then closes every connection. Closing the
+// listener also removes the socket file.
 func (s *Server) Shutdown() {
-	s.historyCache.Stop()
+	s.shutdownOnce.Do(func() {
+		go s.shutdown()
+	})
+}
+
+func (s *Server) shutdown() {
+	defer close(s.done)
+	s.log.Printf("shutting down")
+	s.histMu.Lock()
+	for _, hc := range s.histories {
+		hc.Stop()
+	}
+	s.histMu.Unlock()
 	s.listener.Close()
-	os.Remove(s.portFile)
+
+	// Unblock readers so no new requests are taken; handleConn then waits
+	// for the requests it already started.
+	s.connMu.Lock()
+	for c := range s.conns {
+		c.conn.SetReadDeadline(time.Now())
+	}
+	s.connMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		s.log.Printf("requests still in flight after %s, closing connections", drainTimeout)
+		s.connMu.Lock()
+		for c := range s.conns {
+			c.stop()
+			c.conn.Close()
+		}
+		s.connMu.Unlock()
+	}
+
+	s.persist()
+	if pid, err := ReadPID(s.opts.PIDFile); err == nil && pid == os.Getpid() {
+		os.Remove(s.opts.PIDFile)
+	}
+	s.log.Printf("stopped")
 }
 
-func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
+// persistLoop saves stats and cached suggestions every minute, so a daemon
+// that is killed loses little.
+func (s *Server) persistLoop() {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.persist()
+		case <-s.done:
+			return
+		}
+	}
+}
 
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
-		return
+func (s *Server) persist() {
+	if err := s.metrics.save(); err != nil {
+		s.log.Printf("saving stats: %v", err)
+	}
+	if d := s.disk.Load(); d != nil {
+		if err := d.flush(); err != nil {
+			s.log.Printf("saving suggestion cache: %v", err)
+		}
 	}
+}
 
-	var req Request
-	if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
-		fmt.Fprintln(conn, "")
-		return
+// history returns the history cache for the shell a request came from,
+// falling back to the daemon's own $SHELL, and the history file it named,
+// falling back to the shell's usual one.
+func (s *Server) history(shell, file string) *HistoryCache {
+	if shell == "" {
+		shell = s.shell
+	}
+	if file == "" {
+		file = history.File(shell)
+	}
+	key := filepath.Base(shell) + "\x00" + file
+	s.histMu.Lock()
+	defer s.histMu.Unlock()
+	hc, ok := s.histories[key]
+	if !ok {
+		hc = NewHistoryCache(shell, file)
+		s.histories[key] = hc
+	}
+	return hc
+}
+
+func (s *Server) serve(conn net.Conn) {
+	c := newServerConn(conn)
+	s.connMu.Lock()
+	s.conns[c] = struct{}{}
+	s.lastActive = time.Now()
+	s.connMu.Unlock()
+
+	s.connWG.Add(1)
+	go func() {
+		defer s.connWG.Done()
+		s.handleConn(c)
+		s.connMu.Lock()
+		delete(s.conns, c)
+		s.lastActive = time.Now()
+		s.connMu.Unlock()
+	}()
+}
+
+// watchIdle shuts the server down once it has had no connections for the
+// idle timeout.
+func (s *Server) watchIdle() {
+	ticker := time.NewTicker(max(min(s.opts.IdleTimeout/4, time.Minute), time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.connMu.Lock()
+			idle := len(s.conns) == 0 && time.Since(s.lastActive) >= s.opts.IdleTimeout
+			s.connMu.Unlock()
+			if idle {
+				s.log.Printf("no clients for %s", s.opts.IdleTimeout)
+				s.Shutdown()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Server) status() *Status {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return &Status{
+		PID:          os.Getpid(),
+		Version:      s.opts.Version,
+		Started:      s.started,
+		Connections:  len(s.conns),
+		BackoffUntil: s.breaker(s.settings.Load().client.Provider()).until(),
+	}
+}
+
+// listenUnix listens on a socket only the current user can open, replacing a
+// stale socket left by a daemon that didn't shut down cleanly.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, 200*time.Millisecond); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("daemon already running on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	oldMask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
 	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// samePeerUser reports whether the process on the other end of conn runs as
+// the same user as the daemon.
+func samePeerUser(conn net.Conn) bool {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return false
+	}
+	uid, err := peerUID(uc)
+	return err == nil && uid == os.Getuid()
+}
 
+func (s *Server) handleConn(c *serverConn) {
+	defer c.close()
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	for scanner.Scan() {
+		var req Request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			c.sendError(0, ErrBadRequest, err.Error())
+			continue
+		}
+		if req.Version != ProtocolVersion {
+			c.sendError(req.ID, ErrUnsupportedVersion, fmt.Sprintf("daemon speaks protocol version %d", ProtocolVersion))
+			continue
+		}
+
+		switch req.Type {
+		case TypeComplete:
+			c.start(req, func(ctx context.Context) {
+				s.complete(ctx, req, c.send)
+			})
+		case TypeCancel:
+			c.cancel(req.ID)
+		case TypePing:
+			c.send(Response{ID: req.ID, Final: true, Model: s.settings.Load().client.Model(), Daemon: s.status()})
+		case TypeStats:
+			if req.Reset {
+				s.metrics.reset()
+			}
+			stats := s.metrics.snapshot()
+			c.send(Response{ID: req.ID, Final: true, Stats: &stats})
+		case TypeFeedback:
+			s.recordFeedback(req)
+			c.send(Response{ID: req.ID, Final: true})
+		case TypeCommand:
+			s.recordCommand(req)
+			c.send(Response{ID: req.ID, Final: true})
+		case TypeReload:
+			if err := s.reload(); err != nil {
+				c.sendError(req.ID, ErrConfig, err.Error())
+				continue
+			}
+			c.send(Response{ID: req.ID, Final: true, Model: s.settings.Load().client.Model()})
+		default:
+			c.sendError(req.ID, ErrUnknownType, fmt.Sprintf("unknown request type %q", req.Type))
+		}
+	}
+}
+
+// complete answers a completion request. A history match is sent as soon as
+// it's found; the final response carries the model's suggestion when it has a
+// usable one, and otherwise repeats the history match.
+func (s *Server) complete(ctx context.Context, req Request, send func(Response)) {
+	start := time.Now()
+	reply := func(resp Response) {
+		resp.ID = req.ID
+		resp.LatencyMS = time.Since(start).Milliseconds()
+		send(resp)
+	}
+	fail := func(code, message string) {
+		reply(Response{Final: true, Error: &Error{Code: code, Message: message}})
+	}
+
+	if req.Names != nil {
+		s.sessions.setNames(req.Session, req.Names)
+	}
 	if req.Buffer == "" {
-		fmt.Fprintln(conn, "")
+		reply(Response{Final: true})
 		return
 	}
-
-	cacheKey := req.CWD + "\x00" + req.Buffer
-	if entry, ok := s.cacheGet(cacheKey); ok {
-		fmt.Fprintln(conn, entry)
+	st := s.settings.Load()
+	if st.zones.Blocks(req.CWD, req.Buffer) {
+		fail(ErrPrivate, "private directory or command")
+		return
+	}
+	if privacy.Paused(req.Session) {
+		fail(ErrPaused, "autocomplete is paused")
 		return
 	}
 
-	historyStr := s.historyCache.Get()
+	debounce := s.sessions.keystroke(req.Session)
+	scope := st.client.Model() + "\x00" + req.CWD
 
-	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	// Join a call already running for what the user typed so far before
+	// superseding the request that started it, so typing along doesn't
+	// throw that call away.
+	prefixFlight, prefix := s.flights.joinPrefix(scope, req.Buffer)
+	if prefixFlight != nil {
+		defer s.flights.leave(prefixFlight)
+	}
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	defer s.sessions.supersede(req.Session, cancel)()
+
+	valid := func(suggestion string) bool {
+		return s.valid(st, req, suggestion)
+	}
+
+	model := st.client.Model()
+	if entry, ok := s.cached(req, valid); ok {
+		s.metrics.update(func(m *Stats) { m.Requests++; m.CacheHits++; m.Suggested++ })
+		reply(Response{Final: true, Suggestion: entry, Source: SourceCache, Model: model})
+		return
+	}
+
+	match, fromHistory := s.feedback.preferred(req.Buffer, valid)
+	source := SourceAccepted
+	if !fromHistory {
+		source = SourceHistory
+		match, fromHistory = s.sessions.lookup(req.Session, req.Buffer, valid)
+	}
+	if !fromHistory {
+		match, fromHistory = s.history(req.Shell, req.HistFile).Lookup(req.Buffer, valid)
+	}
+	historyResp := Response{Suggestion: match.Command, Source: source, Confidence: match.Confidence}
+	if fromHistory {
+		reply(historyResp)
+	}
 
-	suggestion, err := s.client.Complete(ctx, req.Buffer, req.CWD, req.Shell, historyStr)
+	suggestion, coalesced, err := s.suggest(ctx, st, req, scope, prefixFlight, prefix, debounce)
+	if err == nil && suggestion != "" && !valid(suggestion) {
+		suggestion = ""
+	}
+	timedOut := errors.Is(err, context.DeadlineExceeded)
+	var backoff *errBackoff
+	backedOff := errors.As(err, &backoff)
+	var apiErr *suggest.APIError
+	rateLimited := errors.As(err, &apiErr) && apiErr.RateLimited()
+	s.metrics.update(func(m *Stats) {
+		m.Requests++
+		if coalesced {
+			m.Coalesced++
+		}
+		switch {
+		case ctx.Err() != nil:
+			m.Canceled++
+		case backedOff:
+			m.BackedOff++
+		case rateLimited:
+			m.RateLimited++
+		case timedOut:
+			m.Timeouts++
+		case err != nil:
+			m.Errors++
+		}
+		switch {
+		case err == nil && suggestion != "":
+			m.LLMSuggestions++
+		case fromHistory:
+			m.HistoryHits++
+		default:
+			m.Empty++
+		}
+		if fromHistory || (err == nil && suggestion != "") {
+			m.Suggested++
+		}
+	})
 	if err != nil || suggestion == "" {
-		fmt.Fprintln(conn, "")
+		switch {
+		case fromHistory:
+			historyResp.Final = true
+			reply(historyResp)
+		case ctx.Err() != nil:
+			fail(ErrCanceled, "request canceled")
+		case backedOff || rateLimited:
+			fail(ErrBackoff, err.Error())
+		case timedOut:
+			fail(ErrTimeout, "provider did not answer in time")
+		case err != nil:
+			fail(ErrProvider, err.Error())
+		default:
+			reply(Response{Final: true, Model: model})
+		}
 		return
 	}
 
-	s.cachePut(cacheKey, suggestion)
-	fmt.Fprintln(conn, suggestion)
+	s.cache.put(req.CWD, req.Buffer, suggestion)
+	if d := s.disk.Load(); d != nil {
+		d.put(req.CWD, req.Buffer, suggestion)
+	}
+	resp := Response{Final: true, Suggestion: suggestion, Source: SourceLLM, Model: model}
+	if suggestion == match.Command {
+		resp.Confidence = match.Confidence
+	}
+	reply(resp)
 }
 
-func (s *Server) cacheGet(key string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	entry, ok := s.cache[key]
-	if !ok || time.Since(entry.timestamp) > cacheTTL {
+// cached looks in the memory cache, then on disk. Suggestions from disk may
+// be days old, so they must still be valid and are then kept in memory.
+func (s *Server) cached(req Request, valid func(string) bool) (string, bool) {
+	if suggestion, ok := s.cache.get(req.CWD, req.Buffer); ok {
+		return suggestion, true
+	}
+	d := s.disk.Load()
+	if d == nil {
+		return "", false
+	}
+	suggestion, ok := d.get(req.CWD, req.Buffer)
+	if !ok || !valid(suggestion) {
 		return "", false
 	}
-	return entry.suggestion, true
+	s.cache.put(req.CWD, req.Buffer, suggestion)
+	return suggestion, true
 }
 
-func (s *Server) cachePut(key, suggestion string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if len(s.cache) >= cacheMaxEntries {
-		s.evictOldest()
+// breaker returns the circuit breaker for a provider.
+func (s *Server) breaker(provider string) *breaker {
+	s.breakerMu.Lock()
+	defer s.breakerMu.Unlock()
+	b, ok := s.breakers[provider]
+	if !ok {
+		b = &breaker{}
+		s.breakers[provider] = b
 	}
-	s.cache[key] = cacheEntry{suggestion: suggestion, timestamp: time.Now()}
+	return b
 }
 
-func (s *Server) evictOldest() {
-	var oldestKey string
-	var oldestTime time.Time
-	first := true
-	for k, v := range s.cache {
-		if first || v.timestamp.Before(oldestTime) {
-			oldestKey = k
-			oldestTime = v.timestamp
-			first = false
+// suggest gets the model's suggestion for req, sharing provider calls: it
+// reuses a call for a prefix of the buffer when that call's suggestion still
+// fits, and joins an identical call already running. Otherwise it waits out
+// the session's debounce first, in case a newer keystroke supersedes it. The
+// boolean reports whether the result came from a call another request
+// started.
+func (s *Server) suggest(ctx context.Context, st *settings, req Request, scope string, prefixFlight *flight, prefix string, debounce time.Duration) (string, bool, error) {
+	if prefixFlight != nil {
+		suggestion, err := prefixFlight.wait(ctx)
+		if ctx.Err() != nil {
+			return "", true, ctx.Err()
+		}
+		if prefix == req.Buffer || (err == nil && extends(suggestion, req.Buffer)) {
+			return suggestion, true, err
+		}
+	}
+
+	if debounce > 0 {
+		select {
+		case <-time.After(debounce):
+		case <-ctx.Done():
+			return "", false, ctx.Err()
 		}
 	}
-	if oldestKey != "" {
-		delete(s.cache, oldestKey)
+
+	f, shared := s.flights.do(scope, req.Buffer, func(ctx context.Context) (string, error) {
+		b := s.breaker(st.client.Provider())
+		if err := b.allow(ctx); err != nil {
+			return "", err
+		}
+		start := time.Now()
+		suggestion, err := st.client.Complete(ctx, s.input(st, req))
+		b.done(err)
+		if err == nil {
+			s.metrics.observeLatency(time.Since(start))
+		}
+		return suggestion, err
+	})
+	defer s.flights.leave(f)
+	suggestion, err := f.wait(ctx)
+	return suggestion, shared, err
+}
+
+// recordFeedback counts what the user did with a suggestion and remembers
+// it, unless it's private, to rank and prompt for later suggestions.
+func (s *Server) recordFeedback(req Request) {
+	switch req.Event {
+	case EventAccept:
+		s.metrics.update(func(m *Stats) { m.Accepted++ })
+	case EventPartial:
+		s.metrics.update(func(m *Stats) { m.Partial++ })
+	case EventIgnore:
+		s.metrics.update(func(m *Stats) { m.Ignored++ })
+	default:
+		return
+	}
+	if req.Suggestion == "" || s.settings.Load().zones.Blocks(req.CWD, req.Suggestion) {
+		return
 	}
+	s.feedback.record(feedbackEvent{
+		Time:       time.Now(),
+		Event:      req.Event,
+		Buffer:     req.Buffer,
+		Suggestion: req.Suggestion,
+		Accepted:   req.Accepted,
+		CWD:        req.CWD,
+	})
 }
 
-func loadGroqModel() string {
-	path, err := config.ConfigPath()
-	if err != nil {
-		return ""
+// recordCommand remembers a command the session ran, unless it's private.
+func (s *Server) recordCommand(req Request) {
+	cmd := strings.TrimSpace(req.Command)
+	if req.Session == "" || cmd == "" || history.IsKompleteCommand(cmd) {
+		return
 	}
-	cfg, err := config.Load(path)
+	if s.settings.Load().zones.Blocks(req.CWD, cmd) {
+		return
+	}
+	s.sessions.add(req.Session, suggest.SessionCommand{Command: cmd, CWD: req.CWD, Exit: req.Exit})
+
+	duration := time.Duration(req.DurationMS) * time.Millisecond
+	err := s.ran.Add(history.Record{
+		Time:     time.Now().Add(-duration),
+		Command:  cmd,
+		CWD:      req.CWD,
+		Repo:     history.RepoRoot(req.CWD),
+		Exit:     req.Exit,
+		Duration: duration,
+		Session:  req.Session,
+	})
 	if err != nil {
-		return ""
+		s.log.Printf("record command: %v", err)
+	}
+}
+
+// relevantHistory is what the model sees of the user's history: the commands
+// they run most around req.CWD, or the end of their history file until
+// komplete has recorded any.
+func (s *Server) relevantHistory(req Request, repo string) string {
+	if commands := s.ran.Frecent(req.Shell, req.CWD, repo, history.MaxCommands); len(commands) > 0 {
+		return strings.Join(commands, "\n")
+	}
+	return s.history(req.Shell, req.HistFile).Get()
+}
+
+func (s *Server) input(st *settings, req Request) suggest.Input {
+	repo := history.RepoRoot(req.CWD)
+	in := suggest.Input{
+		Buffer:   req.Buffer,
+		CWD:      req.CWD,
+		Shell:    req.Shell,
+		History:  st.zones.FilterHistory(s.relevantHistory(req, repo)),
+		Projects: ictx.FormatProjects(st.zones.FilterProjects(s.projects.Detect(req.CWD, repo)), suggest.MaxProjectTasks),
+		Git:      s.repos.Get(repo).String(),
+	}
+	for _, c := range s.sessions.recent(req.Session) {
+		if !st.zones.PrivateCommand(c.Command) {
+			in.Session = append(in.Session, c)
+		}
+	}
+	for _, ex := range s.feedback.examples(req.Buffer) {
+		if !st.zones.PrivateCommand(ex.Accepted) {
+			in.Examples = append(in.Examples, ex)
+		}
+	}
+	// The path being typed may lead into a private directory.
+	for _, dir := range []string{req.CWD, files.PartialDir(req.Buffer, req.CWD)} {
+		if dir == "" || st.zones.PrivateDir(dir) {
+			continue
+		}
+		if l, ok := s.listings.List(dir); ok {
+			in.Listings = append(in.Listings, l)
+		}
+	}
+	for _, note := range st.budget.Fit([]ictx.Section{
+		{Name: "git", Text: &in.Git},
+		{Name: "project tasks", Text: &in.Projects},
+		{Name: "shell history", Text: &in.History},
+	}) {
+		s.log.Printf("context: %s", note)
+	}
+	return in
+}
+
+// valid reports whether a suggestion isn't private or one the user keeps
+// ignoring, runs an installed command or one of the session's aliases and
+// functions, and every path it adds to the buffer exists, so we never show a
+// command for a tool that isn't there or a file the model invented.
+func (s *Server) valid(st *settings, req Request, suggestion string) bool {
+	if st.zones.PrivateCommand(suggestion) || s.feedback.rejected(suggestion) {
+		return false
+	}
+	name := commands.Entrypoint(suggestion)
+	if !s.sessions.defines(req.Session, name) && !s.commands.Exists(name, req.Path, req.CWD) {
+		return false
 	}
-	return cfg["groq_model"]
+	_, missing := files.MissingPath(suggestion, len(req.Buffer), req.CWD)
+	return !missing
 }
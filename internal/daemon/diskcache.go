@@ -0,0 +1,180 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	diskCacheFormat      = 1
+	defaultDiskCacheSize = 5000
+	defaultDiskCacheTTL  = 7 * 24 * time.Hour
+)
+
+// diskCache keeps model suggestions across daemon restarts in one file per
+// model under the user cache directory. Entries live in memory and are
+// merged into the file on flush, under a lock, so daemons sharing the file
+// don't lose each other's entries.
+type diskCache struct {
+	path  string
+	model string
+	mem   *suggestionCache
+
+	mu    sync.Mutex
+	dirty bool
+}
+
+type diskCacheFile struct {
+	Format  int              `json:"format"`
+	Model   string           `json:"model"`
+	Entries []diskCacheEntry `json:"entries"`
+}
+
+type diskCacheEntry struct {
+	CWD        string    `json:"cwd"`
+	Buffer     string    `json:"buffer"`
+	Suggestion string    `json:"suggestion"`
+	Time       time.Time `json:"time"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func diskCachePath(model string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name := "suggestions-" + unsafeFileChars.ReplaceAllString(model, "_") + ".json"
+	return filepath.Join(dir, "komplete", name), nil
+}
+
+// openDiskCache loads the cache for model. A missing, unreadable or
+// outdated file just means starting empty.
+func openDiskCache(model string, size int, ttl time.Duration) (*diskCache, error) {
+	path, err := diskCachePath(model)
+	if err != nil {
+		return nil, err
+	}
+	d := &diskCache{path: path, model: model, mem: newSuggestionCache(size, ttl)}
+	d.mem.restore(d.read())
+	return d, nil
+}
+
+func (d *diskCache) get(cwd, buffer string) (string, bool) {
+	return d.mem.get(cwd, buffer)
+}
+
+func (d *diskCache) put(cwd, buffer, suggestion string) {
+	d.mem.put(cwd, buffer, suggestion)
+	d.mu.Lock()
+	d.dirty = true
+	d.mu.Unlock()
+}
+
+func (d *diskCache) configure(size int, ttl time.Duration) {
+	d.mem.configure(size, ttl)
+}
+
+func (d *diskCache) read() []cacheEntry {
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil
+	}
+	var f diskCacheFile
+	if json.Unmarshal(data, &f) != nil || f.Format != diskCacheFormat || f.Model != d.model {
+		return nil
+	}
+	entries := make([]cacheEntry, 0, len(f.Entries))
+	for _, e := range f.Entries {
+		entries = append(entries, cacheEntry{key: cacheKey(e.CWD, e.Buffer), suggestion: e.Suggestion, timestamp: e.Time})
+	}
+	return entries
+}
+
+// flush merges new entries into the file if there are any.
+func (d *diskCache) flush() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.dirty {
+		return nil
+	}
+
+	dir := filepath.Dir(d.path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(d.path+".lock", os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	// Pick up what other daemons wrote since we loaded.
+	d.mem.restore(d.read())
+
+	f := diskCacheFile{Format: diskCacheFormat, Model: d.model}
+	for _, e := range d.mem.snapshot() {
+		cwd, buffer, ok := strings.Cut(e.key, "\x00")
+		if !ok {
+			continue
+		}
+		f.Entries = append(f.Entries, diskCacheEntry{CWD: cwd, Buffer: buffer, Suggestion: e.suggestion, Time: e.timestamp})
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".suggestions-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+// configureDiskCache opens, resizes, or closes the disk cache to match st,
+// switching files when the model changes.
+func (s *Server) configureDiskCache(st *settings) {
+	cur := s.disk.Load()
+	model := st.client.Model()
+	if cur != nil && cur.model == model && st.diskCacheSize > 0 {
+		cur.configure(st.diskCacheSize, st.diskCacheTTL)
+		return
+	}
+	if cur != nil {
+		if err := cur.flush(); err != nil {
+			s.log.Printf("saving suggestion cache: %v", err)
+		}
+	}
+	if st.diskCacheSize == 0 {
+		s.disk.Store(nil)
+		return
+	}
+	d, err := openDiskCache(model, st.diskCacheSize, st.diskCacheTTL)
+	if err != nil {
+		s.log.Printf("opening suggestion cache: %v", err)
+	}
+	s.disk.Store(d)
+}
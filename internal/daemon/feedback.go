@@ -0,0 +1,261 @@
+package daemon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeke-john/komplete/internal/config"
+	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/suggest"
+)
+
+const (
+	feedbackKeep     = 1000
+	feedbackExamples = 5
+	// A suggestion ignored this many times and never accepted isn't shown
+	// again.
+	rejectAfter = 3
+)
+
+type feedbackEvent struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Buffer     string    `json:"buffer"`
+	Suggestion string    `json:"suggestion"`
+	Accepted   string    `json:"accepted,omitempty"`
+	CWD        string    `json:"cwd,omitempty"`
+}
+
+type feedbackScore struct {
+	accepts, ignores int
+	last             time.Time
+}
+
+// feedbackStore remembers what the user did with suggestions, appending each
+// event to a JSONL file in the state directory and keeping the most recent
+// feedbackKeep in memory.
+type feedbackStore struct {
+	path string
+
+	mu     sync.Mutex
+	events []feedbackEvent
+	// scores tallies the events by the suggestion's first word, then the
+	// suggestion, so a lookup only visits commands the buffer could start.
+	scores map[string]map[string]*feedbackScore
+}
+
+// FeedbackPath is where the daemon stores suggestion feedback.
+func FeedbackPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "feedback.jsonl"), nil
+}
+
+func newFeedbackStore(path string) *feedbackStore {
+	f := &feedbackStore{path: path, scores: make(map[string]map[string]*feedbackScore)}
+	file, err := os.Open(path)
+	if err != nil {
+		return f
+	}
+	var events []feedbackEvent
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), maxRequestSize)
+	for scanner.Scan() {
+		var ev feedbackEvent
+		if json.Unmarshal(scanner.Bytes(), &ev) == nil {
+			events = append(events, ev)
+		}
+	}
+	file.Close()
+
+	if len(events) > feedbackKeep {
+		events = events[len(events)-feedbackKeep:]
+		f.rewrite(events)
+	}
+	for _, ev := range events {
+		f.add(ev)
+	}
+	return f
+}
+
+func (f *feedbackStore) record(ev feedbackEvent) {
+	f.mu.Lock()
+	f.add(ev)
+	compact := len(f.events) > 2*feedbackKeep
+	if compact {
+		events := f.events[len(f.events)-feedbackKeep:]
+		f.events = nil
+		f.scores = make(map[string]map[string]*feedbackScore)
+		for _, ev := range events {
+			f.add(ev)
+		}
+	}
+	events := f.events
+	f.mu.Unlock()
+
+	if compact {
+		f.rewrite(events)
+		return
+	}
+	f.append(ev)
+}
+
+// add must be called with mu held.
+func (f *feedbackStore) add(ev feedbackEvent) {
+	f.events = append(f.events, ev)
+	word := firstWord(ev.Suggestion)
+	cmds := f.scores[word]
+	if cmds == nil {
+		cmds = make(map[string]*feedbackScore)
+		f.scores[word] = cmds
+	}
+	sc := cmds[ev.Suggestion]
+	if sc == nil {
+		sc = &feedbackScore{}
+		cmds[ev.Suggestion] = sc
+	}
+	switch ev.Event {
+	case EventAccept:
+		sc.accepts++
+		sc.last = ev.Time
+	case EventIgnore:
+		sc.ignores++
+	}
+}
+
+func (f *feedbackStore) append(ev feedbackEvent) {
+	var line bytes.Buffer
+	enc := json.NewEncoder(&line)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ev); err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	file.Write(line.Bytes())
+}
+
+func (f *feedbackStore) rewrite(events []feedbackEvent) {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".feedback-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, ev := range events {
+		enc.Encode(ev)
+	}
+	if w.Flush() != nil || tmp.Close() != nil {
+		return
+	}
+	os.Rename(tmp.Name(), f.path)
+}
+
+// preferred returns the completion of prefix the user has accepted most
+// often, net of the times they ignored it, that satisfies accept.
+func (f *feedbackStore) preferred(prefix string, accept func(string) bool) (history.Match, bool) {
+	type candidate struct {
+		command string
+		score   feedbackScore
+	}
+	var candidates []candidate
+	collect := func(cmds map[string]*feedbackScore) {
+		for cmd, sc := range cmds {
+			if sc.accepts > sc.ignores && len(cmd) > len(prefix) && strings.HasPrefix(cmd, prefix) {
+				candidates = append(candidates, candidate{cmd, *sc})
+			}
+		}
+	}
+	f.mu.Lock()
+	if word, _, ok := strings.Cut(prefix, " "); ok {
+		collect(f.scores[word])
+	} else {
+		for w, cmds := range f.scores {
+			if strings.HasPrefix(w, prefix) {
+				collect(cmds)
+			}
+		}
+	}
+	f.mu.Unlock()
+
+	var best *candidate
+	for i := range candidates {
+		c := &candidates[i]
+		if !accept(c.command) {
+			continue
+		}
+		if best == nil || c.score.accepts-c.score.ignores > best.score.accepts-best.score.ignores ||
+			(c.score.accepts-c.score.ignores == best.score.accepts-best.score.ignores && c.score.last.After(best.score.last)) {
+			best = c
+		}
+	}
+	if best == nil {
+		return history.Match{}, false
+	}
+	confidence := float64(best.score.accepts) / float64(best.score.accepts+best.score.ignores)
+	return history.Match{Command: best.command, Confidence: confidence}, true
+}
+
+// rejected reports whether the user keeps ignoring suggestion.
+func (f *feedbackStore) rejected(suggestion string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sc := f.scores[firstWord(suggestion)][suggestion]
+	return sc != nil && sc.accepts == 0 && sc.ignores >= rejectAfter
+}
+
+// examples returns recent accepted completions, those starting with the same
+// word as buffer first.
+func (f *feedbackStore) examples(buffer string) []suggest.Example {
+	word, _, _ := strings.Cut(strings.TrimSpace(buffer), " ")
+	var related, other []suggest.Example
+	seen := make(map[string]bool)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.events) - 1; i >= 0 && len(related) < feedbackExamples; i-- {
+		ev := f.events[i]
+		accepted := ev.Suggestion
+		if ev.Event == EventPartial {
+			accepted = strings.TrimSpace(ev.Accepted)
+		} else if ev.Event != EventAccept {
+			continue
+		}
+		if accepted == "" || accepted == ev.Buffer || seen[accepted] {
+			continue
+		}
+		seen[accepted] = true
+		ex := suggest.Example{Typed: ev.Buffer, Accepted: accepted}
+		if first, _, _ := strings.Cut(accepted, " "); first == word {
+			related = append(related, ex)
+		} else {
+			other = append(other, ex)
+		}
+	}
+	examples := append(related, other...)
+	if len(examples) > feedbackExamples {
+		examples = examples[:feedbackExamples]
+	}
+	return examples
+}
+
+func firstWord(command string) string {
+	word, _, _ := strings.Cut(command, " ")
+	return word
+}
@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// flight is one provider call shared by every request waiting on it.
+type flight struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	val    string
+	err    error
+
+	waiters int // guarded by flightGroup.mu
+}
+
+// flightGroup runs at most one provider call per key at a time. A call is
+// canceled once every request waiting on it has gone away.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{flights: make(map[string]*flight)}
+}
+
+// joinPrefix joins the call in flight for the longest prefix of buffer, if
+// any. The caller must leave it.
+func (g *flightGroup) joinPrefix(scope, buffer string) (*flight, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for n := len(buffer); n > 0; n-- {
+		if f, ok := g.flights[scope+"\x00"+buffer[:n]]; ok {
+			f.waiters++
+			return f, buffer[:n]
+		}
+	}
+	return nil, ""
+}
+
+// do joins the call for buffer or starts fn for it. The boolean reports
+// whether the call was already running. The caller must leave it.
+func (g *flightGroup) do(scope, buffer string, fn func(context.Context) (string, error)) (*flight, bool) {
+	key := scope + "\x00" + buffer
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if f, ok := g.flights[key]; ok {
+		f.waiters++
+		return f, true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	f := &flight{done: make(chan struct{}), cancel: cancel, waiters: 1}
+	g.flights[key] = f
+	go func() {
+		defer cancel()
+		f.val, f.err = fn(ctx)
+		g.mu.Lock()
+		if g.flights[key] == f {
+			delete(g.flights, key)
+		}
+		g.mu.Unlock()
+		close(f.done)
+	}()
+	return f, false
+}
+
+func (g *flightGroup) leave(f *flight) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f.waiters--
+	if f.waiters == 0 {
+		f.cancel()
+	}
+}
+
+// wait returns the call's result, or ctx's error if ctx ends first.
+func (f *flight) wait(ctx context.Context) (string, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// extends reports whether suggestion completes buffer.
+func extends(suggestion, buffer string) bool {
+	return len(suggestion) > len(buffer) && strings.HasPrefix(suggestion, buffer)
+}
@@ -1,28 +1,47 @@
 package daemon
 
 import (
+	"os"
 	"sync"
 	"time"
 
 	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/watch"
 )
 
+// historySettle lets a burst of writes to the history file finish before we
+// re-read it.
+const historySettle = 200 * time.Millisecond
+
+// HistoryCache holds one shell's history file, re-read whenever the file
+// changes.
 type HistoryCache struct {
-	mu       sync.RWMutex
-	shell    string
-	cached   string
-	interval time.Duration
-	stopCh   chan struct{}
+	mu     sync.RWMutex
+	shell  string
+	path   string
+	cached string
+	index  *history.Index
+	// info, offset and recent are only touched by refresh: the file last
+	// read, how far into it, and its last few entries.
+	info    os.FileInfo
+	offset  int64
+	recent  []history.Entry
+	watcher *watch.Watcher
+	stopCh  chan struct{}
 }
 
-func NewHistoryCache(shell string, interval time.Duration) *HistoryCache {
+// NewHistoryCache watches the history file at path, written by shell.
+func NewHistoryCache(shell, path string) *HistoryCache {
 	hc := &HistoryCache{
-		shell:    shell,
-		interval: interval,
-		stopCh:   make(chan struct{}),
+		shell:  shell,
+		path:   path,
+		stopCh: make(chan struct{}),
 	}
 	hc.refresh()
-	go hc.loop()
+	if path != "" {
+		hc.watcher = watch.File(path)
+		go hc.loop()
+	}
 	return hc
 }
 
@@ -32,23 +51,67 @@ func (hc *HistoryCache) Get() string {
 	return hc.cached
 }
 
+// Lookup returns the most recent history command extending prefix that
+// satisfies accept.
+func (hc *HistoryCache) Lookup(prefix string, accept func(string) bool) (history.Match, bool) {
+	hc.mu.RLock()
+	index := hc.index
+	hc.mu.RUnlock()
+	return index.Lookup(prefix, accept)
+}
+
 func (hc *HistoryCache) Stop() {
 	close(hc.stopCh)
+	if hc.watcher != nil {
+		hc.watcher.Close()
+	}
 }
 
+// refresh reads what the shell appended to the history file since the last
+// read, or the whole file again when it was replaced or cut short, as shells
+// do when trimming their history.
 func (hc *HistoryCache) refresh() {
-	result := history.GetShellHistory(hc.shell)
+	info, err := os.Stat(hc.path)
+	if hc.path == "" || err != nil || hc.info == nil || !os.SameFile(hc.info, info) || info.Size() < hc.offset {
+		hc.offset, hc.recent = 0, nil
+		hc.mu.Lock()
+		hc.index = nil
+		hc.mu.Unlock()
+	}
+	hc.info = info
+
+	var entries []history.Entry
+	if err == nil {
+		entries, hc.offset, _ = history.Tail(hc.shell, hc.path, hc.offset)
+	}
+	hc.recent = append(hc.recent, entries...)
+	hc.recent = hc.recent[max(len(hc.recent)-history.MaxCommands, 0):]
+
+	hc.mu.RLock()
+	index := hc.index
+	hc.mu.RUnlock()
+	if index == nil || len(entries) > 0 {
+		index = index.Extend(history.Commands(entries))
+	}
 	hc.mu.Lock()
-	hc.cached = result
+	hc.cached = history.Summary(hc.recent)
+	hc.index = index
 	hc.mu.Unlock()
 }
 
 func (hc *HistoryCache) loop() {
-	ticker := time.NewTicker(hc.interval)
-	defer ticker.Stop()
 	for {
 		select {
-		case <-ticker.C:
+		case <-hc.watcher.C:
+			select {
+			case <-time.After(historySettle):
+			case <-hc.stopCh:
+				return
+			}
+			select {
+			case <-hc.watcher.C:
+			default:
+			}
 			hc.refresh()
 		case <-hc.stopCh:
 			return
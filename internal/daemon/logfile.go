@@ -0,0 +1,81 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const (
+	logMaxSize = 1 << 20
+	logKeep    = 3
+)
+
+// LogFile is an append-only log that rotates to name.1, name.2, ... once it
+// grows past logMaxSize, keeping logKeep old files.
+type LogFile struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func OpenLogFile(path string) (*LogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	l := &LogFile{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size+int64(len(p)) > logMaxSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = info.Size()
+	return nil
+}
+
+func (l *LogFile) rotate() error {
+	l.file.Close()
+	for i := logKeep - 1; i >= 1; i-- {
+		os.Rename(rotatedName(l.path, i), rotatedName(l.path, i+1))
+	}
+	os.Rename(l.path, rotatedName(l.path, 1))
+	return l.open()
+}
+
+func rotatedName(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
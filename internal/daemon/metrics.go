@@ -0,0 +1,208 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/zeke-john/komplete/internal/config"
+)
+
+const latencySamples = 1000
+
+// Stats are the daemon's autocomplete counters since Since. Requests counts
+// completions that weren't skipped as private or paused; Suggested counts
+// the responses that carried a suggestion, several of which may go by while
+// the user types. Accepted, Partial and Ignored count the suggestions the
+// shell reported the user acting on, once each.
+type Stats struct {
+	Since          time.Time `json:"since"`
+	Requests       int64     `json:"requests"`
+	CacheHits      int64     `json:"cache_hits"`
+	HistoryHits    int64     `json:"history_hits"`
+	LLMSuggestions int64     `json:"llm_suggestions"`
+	Empty          int64     `json:"empty"`
+	Suggested      int64     `json:"suggested"`
+	Accepted       int64     `json:"accepted"`
+	Partial        int64     `json:"partial_accepts"`
+	Ignored        int64     `json:"ignored"`
+	Errors         int64     `json:"errors"`
+	Timeouts       int64     `json:"timeouts"`
+	Canceled       int64     `json:"canceled"`
+	Coalesced      int64     `json:"coalesced"`
+	RateLimited    int64     `json:"rate_limited"`
+	BackedOff      int64     `json:"backed_off"`
+	Latency        Latency   `json:"provider_latency_ms"`
+}
+
+// Latency summarizes the most recent provider round trips.
+type Latency struct {
+	Samples int   `json:"samples"`
+	P50     int64 `json:"p50"`
+	P90     int64 `json:"p90"`
+	P99     int64 `json:"p99"`
+}
+
+func (s Stats) CacheHitRate() float64 {
+	return ratio(s.CacheHits, s.Requests)
+}
+
+// Shown is how many suggestions the shell reported on.
+func (s Stats) Shown() int64 {
+	return s.Accepted + s.Partial + s.Ignored
+}
+
+// AcceptanceRate is the share of shown suggestions accepted in full.
+func (s Stats) AcceptanceRate() float64 {
+	return ratio(s.Accepted, s.Shown())
+}
+
+func ratio(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// metrics keeps Stats in memory and persists them to a file in the state
+// directory so they survive daemon restarts.
+type metrics struct {
+	path string
+
+	mu        sync.Mutex
+	stats     Stats
+	latencies []int64
+	next      int
+	dirty     bool
+}
+
+type metricsFile struct {
+	Stats     Stats   `json:"stats"`
+	Latencies []int64 `json:"latencies"`
+}
+
+func newMetrics(path string) *metrics {
+	m := &metrics{path: path}
+	m.stats.Since = time.Now()
+	if data, err := os.ReadFile(path); err == nil {
+		var f metricsFile
+		if json.Unmarshal(data, &f) == nil && !f.Stats.Since.IsZero() {
+			m.stats = f.Stats
+			m.latencies = f.Latencies
+			if len(m.latencies) > latencySamples {
+				m.latencies = m.latencies[len(m.latencies)-latencySamples:]
+			}
+			m.next = len(m.latencies) % latencySamples
+		}
+	}
+	return m
+}
+
+// StatsPath is where the daemon persists its stats.
+func StatsPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "stats.json"), nil
+}
+
+// LoadStats reads stats persisted by a daemon that isn't running.
+func LoadStats(path string) (Stats, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	var f metricsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Stats{}, err
+	}
+	f.Stats.Latency = summarize(f.Latencies)
+	return f.Stats, nil
+}
+
+func (m *metrics) update(fn func(*Stats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fn(&m.stats)
+	m.dirty = true
+}
+
+func (m *metrics) observeLatency(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.latencies) < latencySamples {
+		m.latencies = append(m.latencies, d.Milliseconds())
+	} else {
+		m.latencies[m.next] = d.Milliseconds()
+	}
+	m.next = (m.next + 1) % latencySamples
+	m.dirty = true
+}
+
+func (m *metrics) snapshot() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats
+	s.Latency = summarize(m.latencies)
+	return s
+}
+
+func (m *metrics) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats = Stats{Since: time.Now()}
+	m.latencies = nil
+	m.next = 0
+	m.dirty = true
+}
+
+func summarize(latencies []int64) Latency {
+	if len(latencies) == 0 {
+		return Latency{}
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	at := func(p float64) int64 {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	return Latency{Samples: len(sorted), P50: at(0.50), P90: at(0.90), P99: at(0.99)}
+}
+
+// save writes the stats if they changed since the last save, replacing the
+// file atomically.
+func (m *metrics) save() error {
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	// Oldest first, so a daemon loading the file overwrites the oldest.
+	latencies := append(slices.Clone(m.latencies[m.next:]), m.latencies[:m.next]...)
+	data, err := json.Marshal(metricsFile{Stats: m.stats, Latencies: latencies})
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), ".stats-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
@@ -0,0 +1,217 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+)
+
+// ProtocolVersion is the version of the daemon wire protocol. Clients send
+// one JSON Request per line and the daemon answers with JSON Response lines.
+// A connection may stay open for any number of requests, and responses to
+// different requests may interleave; each request gets zero or more interim
+// responses followed by exactly one with Final set.
+const ProtocolVersion = 1
+
+// Request types.
+const (
+	TypeComplete = "complete"
+	TypeCancel   = "cancel"
+	TypePing     = "ping"
+	TypeReload   = "reload"
+	TypeStats    = "stats"
+	TypeFeedback = "feedback"
+	// TypeCommand reports a command the session just ran.
+	TypeCommand = "command"
+)
+
+// Feedback events.
+const (
+	EventAccept  = "accept"
+	EventPartial = "partial"
+	EventIgnore  = "ignore"
+)
+
+// Suggestion sources.
+const (
+	SourceCache   = "cache"
+	SourceHistory = "history"
+	// SourceAccepted is a completion the user accepted before.
+	SourceAccepted = "accepted"
+	SourceLLM      = "llm"
+)
+
+// Error codes.
+const (
+	ErrBadRequest         = "bad_request"
+	ErrUnsupportedVersion = "unsupported_version"
+	ErrUnknownType        = "unknown_type"
+	ErrPrivate            = "private"
+	ErrPaused             = "paused"
+	ErrTimeout            = "timeout"
+	ErrProvider           = "provider_error"
+	ErrCanceled           = "canceled"
+	ErrConfig             = "config_error"
+	ErrBackoff            = "backoff"
+)
+
+type Request struct {
+	Version int    `json:"v"`
+	ID      uint64 `json:"id"`
+	Type    string `json:"type"`
+	Session string `json:"session,omitempty"`
+	// Close asks the daemon to close the connection after the final response
+	// to this request, for one-shot clients.
+	Close bool `json:"close,omitempty"`
+
+	Buffer string `json:"buffer,omitempty"`
+	CWD    string `json:"cwd,omitempty"`
+	Shell  string `json:"shell,omitempty"`
+	Path   string `json:"path,omitempty"`
+	// HistFile is the shell's history file, when the user moved it.
+	HistFile string `json:"histfile,omitempty"`
+	// Names are the aliases and functions the session's shell defines,
+	// which nothing outside the shell can see. Plugins send them on a
+	// completion request after they change.
+	Names []string `json:"names,omitempty"`
+
+	// Event and Suggestion describe what the user did with a suggestion
+	// shown for Buffer, in a feedback request. Accepted is the buffer after
+	// a partial accept.
+	Event      string `json:"event,omitempty"`
+	Suggestion string `json:"suggestion,omitempty"`
+	Accepted   string `json:"accepted,omitempty"`
+
+	// Command ran in CWD for DurationMS and exited with Exit, in a command
+	// request.
+	Command    string `json:"command,omitempty"`
+	Exit       int    `json:"exit,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+
+	// Reset clears the counters, in a stats request.
+	Reset bool `json:"reset,omitempty"`
+}
+
+type Response struct {
+	Version    int     `json:"v"`
+	ID         uint64  `json:"id"`
+	Final      bool    `json:"final"`
+	Suggestion string  `json:"suggestion,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	Model      string  `json:"model,omitempty"`
+	LatencyMS  int64   `json:"latency_ms"`
+	Confidence float64 `json:"confidence,omitempty"`
+	Error      *Error  `json:"error,omitempty"`
+	// Daemon answers a ping.
+	Daemon *Status `json:"daemon,omitempty"`
+	// Stats answers a stats request.
+	Stats *Stats `json:"stats,omitempty"`
+}
+
+type Status struct {
+	PID         int       `json:"pid"`
+	Version     string    `json:"version,omitempty"`
+	Started     time.Time `json:"started"`
+	Connections int       `json:"connections"`
+	// BackoffUntil is set while the provider is rate limiting us or failing.
+	BackoffUntil time.Time `json:"backoff_until,omitzero"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// serverConn serializes writes to one client connection and tracks the
+// requests in flight on it so they can be canceled.
+type serverConn struct {
+	conn net.Conn
+	ctx  context.Context
+	stop context.CancelFunc
+
+	mu       sync.Mutex
+	enc      *json.Encoder
+	inflight map[uint64]*call
+	wg       sync.WaitGroup
+}
+
+type call struct {
+	cancel context.CancelFunc
+}
+
+func newServerConn(conn net.Conn) *serverConn {
+	ctx, stop := context.WithCancel(context.Background())
+	enc := json.NewEncoder(conn)
+	enc.SetEscapeHTML(false)
+	return &serverConn{
+		conn:     conn,
+		ctx:      ctx,
+		stop:     stop,
+		enc:      enc,
+		inflight: make(map[uint64]*call),
+	}
+}
+
+func (c *serverConn) send(resp Response) {
+	resp.Version = ProtocolVersion
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(resp); err != nil {
+		// The client is gone; nothing in flight can be delivered.
+		c.stop()
+	}
+}
+
+func (c *serverConn) sendError(id uint64, code, message string) {
+	c.send(Response{ID: id, Final: true, Error: &Error{Code: code, Message: message}})
+}
+
+// start runs fn for req in its own goroutine with a context that is canceled
+// by cancel(req.ID), by a later request reusing the ID, or when the
+// connection goes away.
+func (c *serverConn) start(req Request, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	cl := &call{cancel: cancel}
+	c.mu.Lock()
+	if prev, ok := c.inflight[req.ID]; ok {
+		prev.cancel()
+	}
+	c.inflight[req.ID] = cl
+	c.mu.Unlock()
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer c.finish(req.ID, cl)
+		fn(ctx)
+		if req.Close {
+			c.conn.Close()
+		}
+	}()
+}
+
+func (c *serverConn) finish(id uint64, cl *call) {
+	cl.cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inflight[id] == cl {
+		delete(c.inflight, id)
+	}
+}
+
+func (c *serverConn) cancel(id uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cl, ok := c.inflight[id]; ok {
+		cl.cancel()
+	}
+}
+
+// close waits for in-flight requests to answer, then closes the connection.
+func (c *serverConn) close() {
+	c.wg.Wait()
+	c.stop()
+	c.conn.Close()
+}
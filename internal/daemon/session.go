@@ -0,0 +1,183 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zeke-john/komplete/internal/history"
+	"github.com/zeke-john/komplete/internal/suggest"
+)
+
+const (
+	sessionCommands = 20
+	// Sessions the plugin hasn't heard from in this long are forgotten.
+	sessionTTL = 12 * time.Hour
+
+	// A gap between keystrokes longer than cadenceReset is a pause, not
+	// typing speed. Sessions typing faster than fastTyping wait a little
+	// before asking the provider, up to maxDebounce, since the next
+	// keystroke will likely supersede the request.
+	cadenceReset = time.Second
+	fastTyping   = 300 * time.Millisecond
+	maxDebounce  = 250 * time.Millisecond
+)
+
+type session struct {
+	commands []suggest.SessionCommand
+	names    map[string]bool
+	seen     time.Time
+
+	lastKey time.Time
+	cadence time.Duration // moving average of gaps between requests
+	current *context.CancelFunc
+}
+
+// sessionStore keeps the commands each shell session reported running, so
+// suggestions see this terminal's latest commands before the shell flushes
+// them to the history file.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*session)}
+}
+
+// get must be called with mu held.
+func (ss *sessionStore) get(id string) *session {
+	now := time.Now()
+	for k, s := range ss.sessions {
+		if now.Sub(s.seen) > sessionTTL {
+			delete(ss.sessions, k)
+		}
+	}
+	s := ss.sessions[id]
+	if s == nil {
+		s = &session{}
+		ss.sessions[id] = s
+	}
+	s.seen = now
+	return s
+}
+
+func (ss *sessionStore) add(id string, cmd suggest.SessionCommand) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.get(id)
+	s.commands = append(s.commands, cmd)
+	if len(s.commands) > sessionCommands {
+		s.commands = s.commands[len(s.commands)-sessionCommands:]
+	}
+}
+
+// setNames replaces the aliases and functions the session's shell defines.
+func (ss *sessionStore) setNames(id string, names []string) {
+	if id == "" {
+		return
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.get(id).names = set
+}
+
+// defines reports whether the session's shell has an alias or function
+// called name.
+func (ss *sessionStore) defines(id, name string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.sessions[id]
+	return s != nil && s.names[name]
+}
+
+// keystroke records a completion request for the session and returns how
+// long to wait before calling the provider for it.
+func (ss *sessionStore) keystroke(id string) time.Duration {
+	if id == "" {
+		return 0
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.get(id)
+	now := time.Now()
+	if gap := now.Sub(s.lastKey); gap < cadenceReset {
+		if s.cadence == 0 {
+			s.cadence = gap
+		} else {
+			s.cadence = (3*s.cadence + gap) / 4
+		}
+	}
+	s.lastKey = now
+	if s.cadence == 0 || s.cadence > fastTyping {
+		return 0
+	}
+	return min(s.cadence*3/2, maxDebounce)
+}
+
+// supersede cancels the session's previous completion request and makes
+// cancel the one to call when the next arrives. The returned func must be
+// called when the request finishes.
+func (ss *sessionStore) supersede(id string, cancel context.CancelFunc) func() {
+	if id == "" {
+		return func() {}
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.get(id)
+	if s.current != nil {
+		(*s.current)()
+	}
+	current := &cancel
+	s.current = current
+	return func() {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		if s.current == current {
+			s.current = nil
+		}
+	}
+}
+
+// recent returns the session's commands, oldest first.
+func (ss *sessionStore) recent(id string) []suggest.SessionCommand {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s := ss.sessions[id]
+	if s == nil {
+		return nil
+	}
+	s.seen = time.Now()
+	return append([]suggest.SessionCommand(nil), s.commands...)
+}
+
+// lookup returns the session's most recent successful command extending
+// prefix that satisfies accept.
+func (ss *sessionStore) lookup(id, prefix string, accept func(string) bool) (history.Match, bool) {
+	cmds := ss.recent(id)
+	var matches []string
+	for i := len(cmds) - 1; i >= 0; i-- {
+		c := cmds[i]
+		if c.Exit == 0 && len(c.Command) > len(prefix) && strings.HasPrefix(c.Command, prefix) {
+			matches = append(matches, c.Command)
+		}
+	}
+	for _, m := range matches {
+		if !accept(m) {
+			continue
+		}
+		count := 0
+		for _, other := range matches {
+			if other == m {
+				count++
+			}
+		}
+		return history.Match{Command: m, Confidence: float64(count) / float64(len(matches))}, true
+	}
+	return history.Match{}, false
+}
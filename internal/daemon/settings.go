@@ -0,0 +1,140 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zeke-john/komplete/internal/config"
+	ictx "github.com/zeke-john/komplete/internal/context"
+	"github.com/zeke-john/komplete/internal/privacy"
+	"github.com/zeke-john/komplete/internal/suggest"
+	"github.com/zeke-john/komplete/internal/watch"
+)
+
+// settings is everything the daemon reads from config.toml. Requests load it
+// once when they start, so a reload never changes one halfway through.
+type settings struct {
+	client    *suggest.Client
+	apiKey    string
+	zones     privacy.Zones
+	cacheSize int
+	cacheTTL  time.Duration
+	// diskCacheSize is zero when the disk cache is off.
+	diskCacheSize int
+	diskCacheTTL  time.Duration
+	// budget bounds the history, git and project tasks in a prompt.
+	budget ictx.Budget
+}
+
+func (s *Server) loadSettings() (*settings, error) {
+	path, err := config.ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	apiKey := config.APIKey(cfg, "groq_api_key")
+	if apiKey == "" {
+		return nil, fmt.Errorf("GROQ_API_KEY not set")
+	}
+	st := &settings{
+		client: suggest.NewClient(apiKey, cfg["groq_model"], s.clientOpts...),
+		apiKey: apiKey,
+		zones:  privacy.FromConfig(cfg),
+	}
+	if st.cacheSize, err = intSetting(cfg, "cache_size", defaultCacheSize, 1); err != nil {
+		return nil, err
+	}
+	if st.cacheTTL, err = durationSetting(cfg, "cache_ttl", defaultCacheTTL); err != nil {
+		return nil, err
+	}
+	if st.diskCacheSize, err = intSetting(cfg, "disk_cache_size", defaultDiskCacheSize, 0); err != nil {
+		return nil, err
+	}
+	if st.diskCacheTTL, err = durationSetting(cfg, "disk_cache_ttl", defaultDiskCacheTTL); err != nil {
+		return nil, err
+	}
+	st.budget = ictx.Budget{Bytes: ictx.MaxBudgetBytes}
+	if st.budget.Tokens, err = intSetting(cfg, "context_budget", ictx.DefaultBudgetTokens, 1); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func intSetting(cfg config.Config, key string, def, minimum int) (int, error) {
+	v := cfg[key]
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < minimum {
+		return 0, fmt.Errorf("%s must be a number of at least %d, got %q", key, minimum, v)
+	}
+	return n, nil
+}
+
+func durationSetting(cfg config.Config, key string, def time.Duration) (time.Duration, error) {
+	v := cfg[key]
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a duration like 90s or 5m, got %q", key, v)
+	}
+	return d, nil
+}
+
+// reload swaps in freshly read settings. Requests in flight finish with the
+// settings they started with; cached suggestions are dropped when the model
+// or key changes.
+func (s *Server) reload() error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	next, err := s.loadSettings()
+	if err != nil {
+		s.log.Printf("reload failed, keeping current settings: %v", err)
+		return err
+	}
+	prev := s.settings.Load()
+	next.client.Inherit(prev.client)
+	s.settings.Store(next)
+	s.cache.configure(next.cacheSize, next.cacheTTL)
+	s.configureDiskCache(next)
+	if prev.client.Model() != next.client.Model() || prev.apiKey != next.apiKey {
+		s.cache.clear()
+		s.breaker(next.client.Provider()).reset()
+		s.log.Printf("reloaded config, model %s", next.client.Model())
+	} else {
+		s.log.Printf("reloaded config")
+	}
+	return nil
+}
+
+// watchConfig reloads whenever config.toml changes.
+func (s *Server) watchConfig() {
+	path, err := config.ConfigPath()
+	if err != nil {
+		return
+	}
+	w := watch.File(path)
+	defer w.Close()
+	for {
+		select {
+		case <-w.C:
+			// Let a burst of writes settle before reading the file.
+			time.Sleep(100 * time.Millisecond)
+			select {
+			case <-w.C:
+			default:
+			}
+			s.reload()
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxEntries   = 50
+	maxCachedDir = 256
+)
+
+// Listing is a bounded, sorted view of a directory's entries. Directories
+// carry a trailing slash.
+type Listing struct {
+	Dir   string
+	Names []string
+	More  int
+}
+
+func (l Listing) String() string {
+	s := strings.Join(l.Names, " ")
+	if l.More > 0 {
+		s += " (+" + strconv.Itoa(l.More) + " more)"
+	}
+	return s
+}
+
+// List reads up to maxEntries entries of dir, in name order.
+func List(dir string) (Listing, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Listing{}, err
+	}
+	l := Listing{Dir: dir}
+	for _, e := range entries {
+		if len(l.Names) >= maxEntries {
+			l.More++
+			continue
+		}
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		l.Names = append(l.Names, name)
+	}
+	return l, nil
+}
+
+// Cache holds listings keyed by directory and re-reads a directory only when
+// its modification time changes.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	modTime time.Time
+	listing Listing
+}
+
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string]cacheEntry)}
+}
+
+func (c *Cache) List(dir string) (Listing, bool) {
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return Listing{}, false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[dir]
+	c.mu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.listing, true
+	}
+
+	listing, err := List(dir)
+	if err != nil {
+		return Listing{}, false
+	}
+
+	c.mu.Lock()
+	if len(c.entries) >= maxCachedDir {
+		c.entries = make(map[string]cacheEntry)
+	}
+	c.entries[dir] = cacheEntry{modTime: info.ModTime(), listing: listing}
+	c.mu.Unlock()
+	return listing, true
+}
+
+// PartialDir returns the directory of the path being typed at the end of
+// buffer, or "" when the last word isn't a path or is in cwd itself.
+func PartialDir(buffer, cwd string) string {
+	if buffer == "" || strings.HasSuffix(buffer, " ") {
+		return ""
+	}
+	fields := strings.Fields(buffer)
+	if len(fields) < 2 {
+		return ""
+	}
+	word := strings.Trim(fields[len(fields)-1], `"'`)
+	idx := strings.LastIndexByte(word, '/')
+	if idx == -1 {
+		return ""
+	}
+	dir := Resolve(word[:idx+1], cwd)
+	if dir == filepath.Clean(cwd) {
+		return ""
+	}
+	return dir
+}
+
+// Resolve expands a leading ~ and makes path absolute relative to cwd.
+func Resolve(path, cwd string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	return filepath.Clean(path)
+}
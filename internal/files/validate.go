@@ -0,0 +1,189 @@
+package files
+
+import (
+	"os"
+	"strings"
+
+	"github.com/zeke-john/komplete/internal/commands"
+)
+
+// pathCommands lists commands whose operands name existing paths. The value
+// is how many leading operands to check: 0 means all of them and -1 all but
+// the last, which is a destination that may not exist yet. Commands that
+// create their operands (mkdir, touch, tee, editors) are deliberately absent.
+var pathCommands = map[string]int{
+	"cat":         0,
+	"less":        0,
+	"more":        0,
+	"head":        0,
+	"tail":        0,
+	"bat":         0,
+	"wc":          0,
+	"stat":        0,
+	"file":        0,
+	"diff":        0,
+	"ls":          0,
+	"cd":          0,
+	"du":          0,
+	"tree":        0,
+	"rm":          0,
+	"rmdir":       0,
+	"open":        0,
+	"cp":          -1,
+	"mv":          -1,
+	"source":      1,
+	".":           1,
+	"sh":          1,
+	"bash":        1,
+	"zsh":         1,
+	"python":      1,
+	"python3":     1,
+	"node":        1,
+	"ruby":        1,
+	"git add":     0,
+	"git rm":      0,
+	"git restore": 0,
+}
+
+type word struct {
+	text string
+	end  int
+	op   bool
+}
+
+// MissingPath returns the first operand of suggestion that should name an
+// existing path but doesn't. Only words ending past typedLen, the part the
+// user has typed so far, are checked, so we only judge what the model added.
+func MissingPath(suggestion string, typedLen int, cwd string) (string, bool) {
+	words := splitWords(suggestion)
+	var segment []word
+	skipNext := false
+	for _, w := range words {
+		if w.op {
+			if strings.Contains(w.text, ">") {
+				skipNext = true
+				continue
+			}
+			if w.text == "<" {
+				continue
+			}
+			if missing, ok := missingInSegment(segment, typedLen, cwd); ok {
+				return missing, true
+			}
+			segment = nil
+			continue
+		}
+		if skipNext {
+			skipNext = false
+			continue
+		}
+		segment = append(segment, w)
+	}
+	return missingInSegment(segment, typedLen, cwd)
+}
+
+func missingInSegment(words []word, typedLen int, cwd string) (string, bool) {
+	for len(words) > 0 && (words[0].text == "sudo" || commands.IsEnvAssignment(words[0].text)) {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return "", false
+	}
+
+	operands := words[1:]
+	limit, ok := pathCommands[words[0].text]
+	if len(words) > 1 {
+		if l, sub := pathCommands[words[0].text+" "+words[1].text]; sub {
+			limit, ok = l, true
+			operands = words[2:]
+		}
+	}
+	if !ok {
+		return "", false
+	}
+
+	args := make([]word, 0, len(operands))
+	for _, w := range operands {
+		if strings.HasPrefix(w.text, "-") {
+			continue
+		}
+		args = append(args, w)
+	}
+	switch {
+	case limit == -1 && len(args) > 0:
+		args = args[:len(args)-1]
+	case limit > 0 && len(args) > limit:
+		args = args[:limit]
+	}
+
+	for _, w := range args {
+		if w.end <= typedLen || !pathLike(w.text, words[0].text) {
+			continue
+		}
+		if _, err := os.Stat(Resolve(w.text, cwd)); err != nil {
+			return w.text, true
+		}
+	}
+	return "", false
+}
+
+func pathLike(s, command string) bool {
+	if s == "" || strings.ContainsAny(s, "*?[{$@=") || strings.Contains(s, "://") {
+		return false
+	}
+	return command == "cd" || strings.ContainsAny(s, "/.~")
+}
+
+// splitWords is a small shell tokenizer: it honors quotes and backslashes and
+// splits out control and redirection operators.
+func splitWords(s string) []word {
+	var words []word
+	var cur strings.Builder
+	inWord := false
+	flush := func(end int) {
+		if inWord {
+			words = append(words, word{text: cur.String(), end: end})
+		}
+		cur.Reset()
+		inWord = false
+	}
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush(i)
+		case c == '\'' || c == '"':
+			inWord = true
+			j := strings.IndexByte(s[i+1:], c)
+			if j == -1 {
+				cur.WriteString(s[i+1:])
+				i = len(s)
+				break
+			}
+			cur.WriteString(s[i+1 : i+1+j])
+			i += j + 1
+		case c == '\\' && i+1 < len(s):
+			inWord = true
+			cur.WriteByte(s[i+1])
+			i++
+		case strings.IndexByte("|;&<>", c) != -1:
+			if c == '>' && inWord && strings.Trim(cur.String(), "0123456789") == "" {
+				cur.Reset()
+				inWord = false
+			}
+			flush(i)
+			j := i
+			for j < len(s) && strings.IndexByte("|;&<>", s[j]) != -1 {
+				j++
+			}
+			words = append(words, word{text: s[i:j], end: j, op: true})
+			i = j - 1
+		default:
+			inWord = true
+			cur.WriteByte(c)
+		}
+	}
+	flush(len(s))
+	return words
+}
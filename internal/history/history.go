@@ -1,28 +1,71 @@
 package history
 
 import (
+	"bytes"
+	"cmp"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-const maxCommands = 10
+// MaxCommands is how many commands of history a model is shown.
+const MaxCommands = 10
+
+const (
+	tailReadSize = 8192
+	noHistory    = "No shell history available."
+)
+
+// Entry is one command from a shell's history file. Time and Duration are
+// zero when the shell didn't record them.
+type Entry struct {
+	Command  string
+	Time     time.Time
+	Duration time.Duration
+}
 
 func GetShellHistory(shell string) string {
-	historyFile := getHistoryFile(shell)
-	if historyFile == "" {
-		return "No shell history available."
+	entries, err := Recent(shell, File(shell), MaxCommands)
+	if err != nil {
+		return noHistory
+	}
+	return Summary(entries)
+}
+
+// Relevant lists the commands most worth showing a model for a request made
+// in cwd: the frecent ones from komplete's store, or the end of the shell's
+// history file while the store is empty.
+func Relevant(shell, cwd, repo string) string {
+	if store, err := DefaultStore(); err == nil {
+		if commands := store.Frecent(shell, cwd, repo, MaxCommands); len(commands) > 0 {
+			return strings.Join(commands, "\n")
+		}
 	}
+	return GetShellHistory(shell)
+}
 
-	commands, err := readLastCommands(historyFile, shell, maxCommands)
-	if err != nil || len(commands) == 0 {
-		return "No shell history available."
+// Summary lists the last few commands of entries, one per line.
+func Summary(entries []Entry) string {
+	if len(entries) == 0 {
+		return noHistory
 	}
+	return strings.Join(Commands(entries[max(len(entries)-MaxCommands, 0):]), "\n")
+}
 
-	return strings.Join(commands, "\n")
+// Commands returns the commands of entries, in the same order.
+func Commands(entries []Entry) []string {
+	commands := make([]string, len(entries))
+	for i, e := range entries {
+		commands[i] = e.Command
+	}
+	return commands
 }
 
-func getHistoryFile(shell string) string {
+// File is the history file for shell, honoring an exported HISTFILE for zsh
+// and bash and fish's fish_history session name.
+func File(shell string) string {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return ""
@@ -31,14 +74,29 @@ func getHistoryFile(shell string) string {
 	shellName := filepath.Base(shell)
 	switch shellName {
 	case "zsh":
-		return filepath.Join(home, ".zsh_history")
+		if f := os.Getenv("HISTFILE"); f != "" {
+			return f
+		}
+		return filepath.Join(cmp.Or(os.Getenv("ZDOTDIR"), home), ".zsh_history")
 	case "bash":
+		if f := os.Getenv("HISTFILE"); f != "" {
+			return f
+		}
 		if path := filepath.Join(home, ".bash_history"); fileExists(path) {
 			return path
 		}
 		return filepath.Join(home, ".history")
 	case "fish":
-		return filepath.Join(home, ".local", "share", "fish", "fish_history")
+		name := "fish"
+		if v, ok := os.LookupEnv("fish_history"); ok {
+			if v == "" {
+				// fish keeps no history at all.
+				return ""
+			}
+			name = v
+		}
+		data := cmp.Or(os.Getenv("XDG_DATA_HOME"), filepath.Join(home, ".local", "share"))
+		return filepath.Join(data, "fish", name+"_history")
 	default:
 		return filepath.Join(home, ".history")
 	}
@@ -49,75 +107,89 @@ func fileExists(path string) bool {
 	return err == nil
 }
 
-const tailReadSize = 8192
-
-func readLastCommands(path string, shell string, n int) ([]string, error) {
-	file, err := os.Open(path)
+// Read returns every command in the history file at path, oldest first,
+// leaving out komplete's own.
+func Read(shell, path string) ([]Entry, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return parse(filepath.Base(shell), data), nil
+}
 
-	stat, err := file.Stat()
+// Tail returns the commands added to the history file at path since offset,
+// oldest first, and the offset to read from next time. It stops at the last
+// complete line, leaving an entry the shell is still writing for later.
+func Tail(shell, path string, offset int64) ([]Entry, int64, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, offset, err
 	}
-
-	size := stat.Size()
-	if size == 0 {
-		return nil, nil
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
 	}
-
-	readSize := int64(tailReadSize)
-	if size < readSize {
-		readSize = size
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, offset, err
 	}
+	end := bytes.LastIndexByte(data, '\n') + 1
+	if end == 0 {
+		return nil, offset, nil
+	}
+	return parse(filepath.Base(shell), data[:end]), offset + int64(end), nil
+}
 
-	buf := make([]byte, readSize)
-	_, err = file.ReadAt(buf, size-readSize)
+// Recent returns the last n commands in the history file at path, oldest
+// first, reading only as much of the end of the file as it needs.
+func Recent(shell, path string, n int) ([]Entry, error) {
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
 
-	chunk := string(buf)
-	if readSize < size {
-		if idx := strings.IndexByte(chunk, '\n'); idx != -1 {
-			chunk = chunk[idx+1:]
-		}
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
 	}
-
-	lines := strings.Split(chunk, "\n")
+	size := stat.Size()
 	shellName := filepath.Base(shell)
-	commands := make([]string, 0, n)
 
-	for i := len(lines) - 1; i >= 0 && len(commands) < n; i-- {
-		cmd := parseHistoryLine(lines[i], shellName)
-		if cmd != "" && !isKompleteCommand(cmd) {
-			commands = append([]string{cmd}, commands...)
+	for readSize := int64(tailReadSize); ; readSize *= 4 {
+		readSize = min(readSize, size)
+		buf := make([]byte, readSize)
+		if _, err := file.ReadAt(buf, size-readSize); err != nil {
+			return nil, err
+		}
+		entries := parse(shellName, buf)
+		if readSize < size {
+			entries = dropPartial(entries)
+		}
+		if len(entries) >= n || readSize == size {
+			return entries[max(len(entries)-n, 0):], nil
 		}
 	}
-
-	return commands, nil
 }
 
-func parseHistoryLine(line string, shellName string) string {
-	switch shellName {
-	case "zsh":
-		if idx := strings.Index(line, ";"); idx != -1 {
-			return strings.TrimSpace(line[idx+1:])
+// dropPartial drops what may be the tail of an entry cut off at the start of
+// a chunk: everything before the first timestamped entry when the shell
+// records times, otherwise the first entry.
+func dropPartial(entries []Entry) []Entry {
+	for i, e := range entries {
+		if !e.Time.IsZero() {
+			return entries[i:]
 		}
-		return strings.TrimSpace(line)
-	case "fish":
-		if strings.HasPrefix(line, "- cmd: ") {
-			return strings.TrimSpace(strings.TrimPrefix(line, "- cmd: "))
-		}
-		return ""
-	default:
-		return strings.TrimSpace(line)
 	}
+	if len(entries) > 0 {
+		return entries[1:]
+	}
+	return entries
 }
 
-func isKompleteCommand(cmd string) bool {
+// IsKompleteCommand reports whether cmd runs komplete itself, which we keep
+// out of the history we learn from.
+func IsKompleteCommand(cmd string) bool {
 	cmd = strings.TrimSpace(cmd)
 	return strings.HasPrefix(cmd, "komplete ") ||
 		strings.HasPrefix(cmd, "./k ") ||
@@ -0,0 +1,145 @@
+package history
+
+import (
+	"container/heap"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxLookupTries bounds how many matches Lookup asks accept about. Accept
+// may look at the filesystem, so when a prefix's recent commands keep failing
+// it gives up rather than trying every match.
+const maxLookupTries = 8
+
+// Index answers prefix queries over a shell history, preferring the most
+// recently used command, the way zsh-autosuggestions does. An Index is never
+// changed once built, so it can be read without locking.
+type Index struct {
+	// entries are sorted by command, so the commands sharing a prefix sit
+	// next to each other.
+	entries []indexEntry
+	// n is how many commands went into the index.
+	n int
+}
+
+type indexEntry struct {
+	command string
+	last    int
+	count   int
+}
+
+// Match is a history command completing a prefix. Confidence is the share of
+// the prefix's matching history that ran this command.
+type Match struct {
+	Command    string
+	Confidence float64
+}
+
+// NewIndex builds an index from commands ordered oldest first.
+func NewIndex(commands []string) *Index {
+	return (*Index)(nil).Extend(commands)
+}
+
+// Extend returns an index that also holds commands, ordered oldest first and
+// run after those already in idx. idx itself is left as it is.
+func (idx *Index) Extend(commands []string) *Index {
+	next := &Index{}
+	if idx != nil {
+		next.entries = slices.Clone(idx.entries)
+		next.n = idx.n
+	}
+	added := make(map[string]*indexEntry)
+	for _, cmd := range commands {
+		e, ok := added[cmd]
+		if !ok {
+			if i, found := next.find(cmd); found {
+				e = &next.entries[i]
+			} else {
+				e = &indexEntry{command: cmd}
+			}
+			added[cmd] = e
+		}
+		e.last = next.n
+		e.count++
+		next.n++
+	}
+
+	var fresh []indexEntry
+	for cmd, e := range added {
+		if _, found := next.find(cmd); !found {
+			fresh = append(fresh, *e)
+		}
+	}
+	if len(fresh) == 0 {
+		return next
+	}
+	slices.SortFunc(fresh, func(a, b indexEntry) int {
+		return strings.Compare(a.command, b.command)
+	})
+	merged := make([]indexEntry, 0, len(next.entries)+len(fresh))
+	old := next.entries
+	for len(old) > 0 && len(fresh) > 0 {
+		if old[0].command < fresh[0].command {
+			merged, old = append(merged, old[0]), old[1:]
+		} else {
+			merged, fresh = append(merged, fresh[0]), fresh[1:]
+		}
+	}
+	next.entries = append(append(merged, old...), fresh...)
+	return next
+}
+
+func (idx *Index) find(cmd string) (int, bool) {
+	return slices.BinarySearchFunc(idx.entries, cmd, func(e indexEntry, cmd string) int {
+		return strings.Compare(e.command, cmd)
+	})
+}
+
+// Lookup returns the most recent command that starts with prefix, is longer
+// than it, and satisfies accept. A nil accept allows every command.
+func (idx *Index) Lookup(prefix string, accept func(string) bool) (Match, bool) {
+	if idx == nil || prefix == "" {
+		return Match{}, false
+	}
+	start := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].command >= prefix
+	})
+	matches := byRecency{entries: idx.entries}
+	total := 0
+	for i := start; i < len(idx.entries) && strings.HasPrefix(idx.entries[i].command, prefix); i++ {
+		if idx.entries[i].command != prefix {
+			matches.order = append(matches.order, i)
+			total += idx.entries[i].count
+		}
+	}
+	// Only the few most recent matches are ever wanted, so take them off a
+	// heap rather than sorting them all.
+	heap.Init(&matches)
+	for tries := 0; matches.Len() > 0 && tries < maxLookupTries; tries++ {
+		m := idx.entries[heap.Pop(&matches).(int)]
+		if accept == nil || accept(m.command) {
+			return Match{Command: m.command, Confidence: float64(m.count) / float64(total)}, true
+		}
+	}
+	return Match{}, false
+}
+
+// byRecency is a heap of positions in entries, most recently run first.
+type byRecency struct {
+	entries []indexEntry
+	order   []int
+}
+
+func (h byRecency) Len() int { return len(h.order) }
+func (h byRecency) Less(i, j int) bool {
+	return h.entries[h.order[i]].last > h.entries[h.order[j]].last
+}
+func (h byRecency) Swap(i, j int) { h.order[i], h.order[j] = h.order[j], h.order[i] }
+func (h *byRecency) Push(x any)   { h.order = append(h.order, x.(int)) }
+
+func (h *byRecency) Pop() any {
+	last := h.order[len(h.order)-1]
+	h.order = h.order[:len(h.order)-1]
+	return last
+}
@@ -0,0 +1,183 @@
+package history
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parse reads the entries of a history file written by shellName.
+func parse(shellName string, data []byte) []Entry {
+	var entries []Entry
+	switch shellName {
+	case "zsh":
+		entries = parseZsh(data)
+	case "bash":
+		entries = parseBash(string(data))
+	case "fish":
+		entries = parseFish(string(data))
+	default:
+		entries = parseLines(string(data))
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		e.Command = strings.TrimSpace(e.Command)
+		if e.Command != "" && !IsKompleteCommand(e.Command) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func parseLines(data string) []Entry {
+	var entries []Entry
+	for _, line := range strings.Split(data, "\n") {
+		entries = append(entries, Entry{Command: line})
+	}
+	return entries
+}
+
+// zshMeta marks a byte zsh stored XORed with 32 so it can't be mistaken for
+// one of its tokens.
+const zshMeta = 0x83
+
+func unmetafy(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] == zshMeta && i+1 < len(data) {
+			i++
+			out = append(out, data[i]^32)
+			continue
+		}
+		out = append(out, data[i])
+	}
+	return out
+}
+
+// parseZsh reads plain and EXTENDED_HISTORY (": start:elapsed;command")
+// entries. zsh writes a newline inside a command as a backslash at the end of
+// the line.
+func parseZsh(data []byte) []Entry {
+	lines := strings.Split(string(unmetafy(data)), "\n")
+	var entries []Entry
+	for i := 0; i < len(lines); i++ {
+		var e Entry
+		line := lines[i]
+		if start, elapsed, rest, ok := zshExtended(line); ok {
+			e.Time = time.Unix(start, 0)
+			e.Duration = time.Duration(elapsed) * time.Second
+			line = rest
+		}
+		var b strings.Builder
+		for strings.HasSuffix(line, "\\") && i+1 < len(lines) {
+			b.WriteString(line[:len(line)-1])
+			b.WriteByte('\n')
+			i++
+			line = lines[i]
+		}
+		b.WriteString(line)
+		e.Command = b.String()
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func zshExtended(line string) (start, elapsed int64, rest string, ok bool) {
+	fields, rest, found := strings.Cut(line, ";")
+	if !found || !strings.HasPrefix(fields, ": ") {
+		return 0, 0, "", false
+	}
+	startField, elapsedField, found := strings.Cut(fields[2:], ":")
+	if !found {
+		return 0, 0, "", false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(startField), 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	elapsed, err = strconv.ParseInt(elapsedField, 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return start, elapsed, rest, true
+}
+
+// parseBash reads one command per line, or, once HISTTIMEFORMAT has put
+// "#<unix time>" lines in the file, everything up to the next timestamp as
+// one entry, the way bash reads multi-line commands back.
+func parseBash(data string) []Entry {
+	var entries []Entry
+	timestamped := false
+	for _, line := range strings.Split(data, "\n") {
+		if t, ok := bashTimestamp(line); ok {
+			entries = append(entries, Entry{Time: t})
+			timestamped = true
+			continue
+		}
+		if timestamped {
+			e := &entries[len(entries)-1]
+			if e.Command != "" {
+				e.Command += "\n"
+			}
+			e.Command += line
+			continue
+		}
+		entries = append(entries, Entry{Command: line})
+	}
+	return entries
+}
+
+func bashTimestamp(line string) (time.Time, bool) {
+	if len(line) < 2 || line[0] != '#' {
+		return time.Time{}, false
+	}
+	sec, err := strconv.ParseInt(line[1:], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(sec, 0), true
+}
+
+// parseFish reads fish's YAML-like history, where each entry is a "- cmd: "
+// line followed by indented fields such as "when: <unix time>".
+func parseFish(data string) []Entry {
+	var entries []Entry
+	for _, line := range strings.Split(data, "\n") {
+		if cmd, ok := strings.CutPrefix(line, "- cmd: "); ok {
+			entries = append(entries, Entry{Command: unescapeFish(cmd)})
+			continue
+		}
+		if when, ok := strings.CutPrefix(line, "  when: "); ok && len(entries) > 0 {
+			if sec, err := strconv.ParseInt(strings.TrimSpace(when), 10, 64); err == nil {
+				entries[len(entries)-1].Time = time.Unix(sec, 0)
+			}
+		}
+	}
+	return entries
+}
+
+// unescapeFish undoes the escaping fish applies to a history command: \\ for
+// a backslash and \n for a newline.
+func unescapeFish(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package history
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	at := func(sec int64) time.Time { return time.Unix(sec, 0) }
+	tests := []struct {
+		name  string
+		shell string
+		data  string
+		want  []Entry
+	}{
+		{
+			name:  "zsh plain",
+			shell: "zsh",
+			data:  "ls -la\ngit status\n",
+			want:  []Entry{{Command: "ls -la"}, {Command: "git status"}},
+		},
+		{
+			name:  "zsh extended",
+			shell: "zsh",
+			data:  ": 1700000000:5;make build\n: 1700000010:0;ls\n",
+			want: []Entry{
+				{Command: "make build", Time: at(1700000000), Duration: 5 * time.Second},
+				{Command: "ls", Time: at(1700000010)},
+			},
+		},
+		{
+			name:  "zsh extended with padded start",
+			shell: "zsh",
+			data:  ":  1700000000:0;echo hi\n",
+			want:  []Entry{{Command: "echo hi", Time: at(1700000000)}},
+		},
+		{
+			name:  "zsh command containing a semicolon",
+			shell: "zsh",
+			data:  ": 1700000000:0;cd src; make\n",
+			want:  []Entry{{Command: "cd src; make", Time: at(1700000000)}},
+		},
+		{
+			name:  "zsh continuation",
+			shell: "zsh",
+			data:  ": 1700000000:2;for f in *; do\\\n  echo $f\\\ndone\nls\n",
+			want: []Entry{
+				{Command: "for f in *; do\n  echo $f\ndone", Time: at(1700000000), Duration: 2 * time.Second},
+				{Command: "ls"},
+			},
+		},
+		{
+			name:  "zsh metafied bytes",
+			shell: "zsh",
+			// "é" has no bytes zsh metafies; "Ã" (0xc3 0x83) and "œ"
+			// (0xc5 0x93) end in bytes zsh writes as 0x83, then the byte XOR 32.
+			data: ": 1700000000:0;echo caf\xc3\xa9 \xc3\x83\xa3 \xc5\x83\xb3\n",
+			want: []Entry{{Command: "echo café Ã œ", Time: at(1700000000)}},
+		},
+		{
+			name:  "zsh line that only looks extended",
+			shell: "zsh",
+			data:  ": not:a;timestamp\n",
+			want:  []Entry{{Command: ": not:a;timestamp"}},
+		},
+		{
+			name:  "bash plain",
+			shell: "bash",
+			data:  "ls\ncd /tmp\n",
+			want:  []Entry{{Command: "ls"}, {Command: "cd /tmp"}},
+		},
+		{
+			name:  "bash timestamps",
+			shell: "bash",
+			data:  "#1700000000\nls\n#1700000060\ngit log\n",
+			want: []Entry{
+				{Command: "ls", Time: at(1700000000)},
+				{Command: "git log", Time: at(1700000060)},
+			},
+		},
+		{
+			name:  "bash multi-line command between timestamps",
+			shell: "bash",
+			data:  "#1700000000\nif true; then\n  echo yes\nfi\n#1700000060\nls\n",
+			want: []Entry{
+				{Command: "if true; then\n  echo yes\nfi", Time: at(1700000000)},
+				{Command: "ls", Time: at(1700000060)},
+			},
+		},
+		{
+			name:  "bash comment that isn't a timestamp",
+			shell: "bash",
+			data:  "#todo\nls\n",
+			want:  []Entry{{Command: "#todo"}, {Command: "ls"}},
+		},
+		{
+			name:  "fish",
+			shell: "fish",
+			data:  "- cmd: git push\n  when: 1700000000\n- cmd: ls\n  when: 1700000005\n  paths:\n    - src\n",
+			want: []Entry{
+				{Command: "git push", Time: at(1700000000)},
+				{Command: "ls", Time: at(1700000005)},
+			},
+		},
+		{
+			name:  "fish escapes",
+			shell: "fish",
+			data:  "- cmd: echo a\\\\b\n  when: 1700000000\n- cmd: begin\\n  echo hi\\nend\n- cmd: printf \\t\n",
+			want: []Entry{
+				{Command: `echo a\b`, Time: at(1700000000)},
+				{Command: "begin\n  echo hi\nend"},
+				{Command: `printf \t`},
+			},
+		},
+		{
+			name:  "unknown shell",
+			shell: "sh",
+			data:  "ls\n\n  pwd  \n",
+			want:  []Entry{{Command: "ls"}, {Command: "pwd"}},
+		},
+		{
+			name:  "komplete's own commands",
+			shell: "zsh",
+			data:  "k list files\nkomplete init zsh\nkubectl get pods\n",
+			want:  []Entry{{Command: "kubectl get pods"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parse(tt.shell, []byte(tt.data))
+			if !slices.EqualFunc(got, tt.want, func(a, b Entry) bool {
+				return a.Command == b.Command && a.Time.Equal(b.Time) && a.Duration == b.Duration
+			}) {
+				t.Errorf("parse(%q, %q) =\n%+v\nwant\n%+v", tt.shell, tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmetafy(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{"\x83\xa3", "\x83"},
+		{"a\x83\xbdb", "a\x9db"},
+		// A trailing meta byte has nothing to unmetafy.
+		{"a\x83", "a\x83"},
+	}
+	for _, tt := range tests {
+		if got := string(unmetafy([]byte(tt.in))); got != tt.want {
+			t.Errorf("unmetafy(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
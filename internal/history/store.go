@@ -0,0 +1,457 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zeke-john/komplete/internal/config"
+)
+
+const (
+	// The store keeps at least this many records, compacting when it holds
+	// twice as many.
+	storeKeep = 50000
+)
+
+// Record is one command run in a shell with the komplete plugin, or one
+// imported from a history file.
+type Record struct {
+	Time     time.Time     `json:"time,omitzero"`
+	Command  string        `json:"command"`
+	CWD      string        `json:"cwd,omitempty"`
+	Repo     string        `json:"repo,omitempty"`
+	Exit     int           `json:"exit,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Session  string        `json:"session,omitempty"`
+	// Shell is set on imported records, which have no CWD.
+	Shell string `json:"shell,omitempty"`
+}
+
+// Store is komplete's own command history, a JSONL file in the state
+// directory that every komplete process may append to. It rereads whatever
+// other processes have added before each lookup.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	records []Record
+	// offset is how much of file records holds.
+	offset int64
+	file   fs.FileInfo
+	// frecent holds the scores for each directory and repo asked about,
+	// kept up to date as records are appended until the recency weights
+	// they were computed with get old.
+	frecent map[string]*frecency
+}
+
+// frecencyTTL is how long scores are updated incrementally before being
+// recomputed with current recency weights.
+const frecencyTTL = time.Minute
+
+type frecency struct {
+	scores map[string]*frecencyScore
+	// scored is how many records the scores count.
+	scored int
+	at     time.Time
+	// ranked is the ranking for n, nil when the scores changed since.
+	ranked []string
+	n      int
+}
+
+type frecencyScore struct {
+	command string
+	score   float64
+	last    time.Time
+	here    bool
+}
+
+// StorePath is where komplete keeps its command history.
+func StorePath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "commands.jsonl"), nil
+}
+
+// NewStore returns the store kept at path. The file is read when it's first
+// needed; a missing file is an empty store.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// DefaultStore returns the store at StorePath.
+func DefaultStore() (*Store, error) {
+	path, err := StorePath()
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(path), nil
+}
+
+// refresh reads records appended since the last read, or the whole file
+// again when another process compacted it. It must be called with mu held.
+func (s *Store) refresh() error {
+	file, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.records, s.offset, s.file, s.frecent = nil, 0, nil, nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if s.file == nil || !os.SameFile(s.file, stat) || stat.Size() < s.offset {
+		s.records, s.offset, s.frecent = nil, 0, nil
+	}
+	s.file = stat
+	if stat.Size() == s.offset {
+		return nil
+	}
+	if _, err := file.Seek(s.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			// A line without its newline is still being written.
+			break
+		}
+		s.offset += int64(len(line))
+		var r Record
+		if json.Unmarshal(line, &r) == nil && r.Command != "" {
+			s.records = append(s.records, r)
+		}
+	}
+	return nil
+}
+
+// Add appends r to the store.
+func (s *Store) Add(r Record) error {
+	return s.write([]Record{r})
+}
+
+// Import adds the commands in entries, read from shell's history file, that
+// the store doesn't already hold, and returns how many it added. Importing
+// the same file twice adds nothing the second time, and commands run after
+// the plugin started recording are left to the plugin's records.
+func (s *Store) Import(shell string, entries []Entry) (int, error) {
+	s.mu.Lock()
+	if err := s.refresh(); err != nil {
+		s.mu.Unlock()
+		return 0, err
+	}
+	// A command may run many times; only the runs beyond those already in
+	// the store are new.
+	type key struct {
+		command string
+		time    int64
+	}
+	have := make(map[key]int)
+	var recording time.Time
+	for _, r := range s.records {
+		have[key{r.Command, r.Time.Unix()}]++
+		if r.Shell == "" && (recording.IsZero() || r.Time.Before(recording)) {
+			recording = r.Time
+		}
+	}
+	s.mu.Unlock()
+
+	var records []Record
+	for _, e := range entries {
+		if !recording.IsZero() && !e.Time.Before(recording) {
+			continue
+		}
+		k := key{e.Command, e.Time.Unix()}
+		if have[k] > 0 {
+			have[k]--
+			continue
+		}
+		records = append(records, Record{
+			Time:     e.Time,
+			Command:  e.Command,
+			Duration: e.Duration,
+			Shell:    filepath.Base(shell),
+		})
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	return len(records), s.write(records)
+}
+
+// write appends records under an exclusive lock on the file, compacting it
+// when it has grown past twice storeKeep.
+func (s *Store) write(records []Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	file, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.refresh(); err != nil {
+		return err
+	}
+	if len(s.records) > 2*storeKeep {
+		return s.compact()
+	}
+	return nil
+}
+
+// lock opens the file for appending and locks it exclusively.
+func (s *Store) lock() (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return nil, err
+	}
+	for {
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+			file.Close()
+			return nil, err
+		}
+		// Another process may have replaced the file while we waited.
+		same, err := sameFile(file, s.path)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if same {
+			return file, nil
+		}
+		file.Close()
+	}
+}
+
+func sameFile(file *os.File, path string) (bool, error) {
+	open, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(open, current), nil
+}
+
+// compact rewrites the file with the newest storeKeep records. It must be
+// called with mu held and the file locked.
+func (s *Store) compact() error {
+	records := slices.Clone(s.records)
+	// Imported records may be older than ones already recorded.
+	slices.SortStableFunc(records, func(a, b Record) int { return a.Time.Compare(b.Time) })
+	records = records[len(records)-storeKeep:]
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".commands-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, r := range records {
+		enc.Encode(r)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	return s.refresh()
+}
+
+// Len is how many records the store holds.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+	return len(s.records)
+}
+
+// Frecent returns up to n distinct commands for a shell in cwd, most
+// relevant first. A command scores for every run, more for recent runs,
+// runs in cwd and runs elsewhere in repo, and less for runs that failed.
+// Only imported records, which have no directory, count for other places,
+// and only those imported from shell's history.
+func (s *Store) Frecent(shell, cwd, repo string, n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh()
+
+	now := time.Now()
+	for key, f := range s.frecent {
+		if now.Sub(f.at) > frecencyTTL {
+			delete(s.frecent, key)
+		}
+	}
+	shell = filepath.Base(shell)
+	key := shell + "\x00" + cwd + "\x00" + repo
+	f := s.frecent[key]
+	if f == nil {
+		f = &frecency{scores: make(map[string]*frecencyScore), at: now}
+		if s.frecent == nil {
+			s.frecent = make(map[string]*frecency)
+		}
+		s.frecent[key] = f
+	}
+	if f.scored < len(s.records) {
+		f.add(s.records[f.scored:], shell, cwd, repo, f.at)
+		f.scored = len(s.records)
+		f.ranked = nil
+	}
+	if f.ranked == nil || f.n != n {
+		f.rank(n)
+	}
+	return f.ranked
+}
+
+// add counts records, weighing their recency as of now.
+func (f *frecency) add(records []Record, shell, cwd, repo string, now time.Time) {
+	for _, r := range records {
+		place := placeWeight(r, shell, cwd, repo)
+		if place == 0 {
+			continue
+		}
+		w := place * recencyWeight(now.Sub(r.Time))
+		if r.Exit != 0 {
+			w /= 4
+		}
+		sc := f.scores[r.Command]
+		if sc == nil {
+			sc = &frecencyScore{command: r.Command}
+			f.scores[r.Command] = sc
+		}
+		sc.score += w
+		sc.here = sc.here || place >= repoWeight
+		if r.Time.After(sc.last) {
+			sc.last = r.Time
+		}
+	}
+}
+
+func (f *frecency) rank(n int) {
+	ranked := make([]*frecencyScore, 0, len(f.scores))
+	for _, sc := range f.scores {
+		ranked = append(ranked, sc)
+	}
+	slices.SortFunc(ranked, func(a, b *frecencyScore) int {
+		// Commands run around here come before imported ones.
+		if a.here != b.here {
+			if a.here {
+				return -1
+			}
+			return 1
+		}
+		if a.score != b.score {
+			if a.score > b.score {
+				return -1
+			}
+			return 1
+		}
+		return b.last.Compare(a.last)
+	})
+
+	f.ranked = make([]string, 0, min(n, len(ranked)))
+	for _, sc := range ranked[:min(n, len(ranked))] {
+		f.ranked = append(f.ranked, sc.command)
+	}
+	f.n = n
+}
+
+const (
+	cwdWeight    = 1
+	repoWeight   = 0.5
+	importWeight = 0.1
+)
+
+func placeWeight(r Record, shell, cwd, repo string) float64 {
+	switch {
+	case r.CWD == "" && r.Shell != shell:
+		return 0
+	case r.CWD == "":
+		return importWeight
+	case r.CWD == cwd:
+		return cwdWeight
+	case repo != "" && (r.Repo == repo || within(r.CWD, repo)):
+		return repoWeight
+	default:
+		return 0
+	}
+}
+
+func within(dir, root string) bool {
+	return strings.HasPrefix(dir, root+string(filepath.Separator))
+}
+
+func recencyWeight(age time.Duration) float64 {
+	switch {
+	case age < time.Hour:
+		return 4
+	case age < 24*time.Hour:
+		return 2
+	case age < 7*24*time.Hour:
+		return 1
+	case age < 30*24*time.Hour:
+		return 0.5
+	default:
+		return 0.25
+	}
+}
+
+// RepoRoot returns the top of the git work tree containing dir, or "" when
+// there is none. It only looks for .git, so it's cheap enough to call for
+// every command.
+func RepoRoot(dir string) string {
+	for dir != "" {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package privacy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/zeke-john/komplete/internal/config"
+)
+
+// Autocomplete is paused by marker files in the state directory: "paused"
+// for every session and "paused-<session>" for one shell. The zsh plugin
+// checks the same paths before contacting the daemon.
+
+// Pause stops autocomplete for session, or for every session when session is
+// empty.
+func Pause(session string) error {
+	path, err := pauseMarker(session)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, nil, 0o600)
+}
+
+// Resume undoes Pause for the same session, or the global pause when session
+// is empty.
+func Resume(session string) error {
+	path, err := pauseMarker(session)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Paused reports whether autocomplete is paused globally or for session.
+func Paused(session string) bool {
+	return markerExists("") || (session != "" && markerExists(session))
+}
+
+func markerExists(session string) bool {
+	path, err := pauseMarker(session)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+func pauseMarker(session string) (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	name := "paused"
+	if session != "" {
+		name += "-" + filepath.Base(session)
+	}
+	return filepath.Join(dir, name), nil
+}
@@ -0,0 +1,169 @@
+package privacy
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/zeke-john/komplete/internal/config"
+	ictx "github.com/zeke-john/komplete/internal/context"
+)
+
+// Zones are the directories and commands komplete must never send to a model
+// provider. Directory globs support ** for any number of path segments;
+// command patterns are shell-style globs over the whole command line.
+type Zones struct {
+	dirs     []string
+	commands []*regexp.Regexp
+}
+
+// Load reads the private_dirs and private_commands config keys, each a comma
+// separated list.
+func Load() Zones {
+	path, err := config.ConfigPath()
+	if err != nil {
+		return Zones{}
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return Zones{}
+	}
+	return FromConfig(cfg)
+}
+
+func FromConfig(cfg config.Config) Zones {
+	var z Zones
+	for _, d := range splitList(cfg["private_dirs"]) {
+		z.dirs = append(z.dirs, filepath.ToSlash(expandHome(d)))
+	}
+	for _, c := range splitList(cfg["private_commands"]) {
+		z.commands = append(z.commands, compileCommand(c))
+	}
+	return z
+}
+
+// PrivateDir reports whether cwd is inside a private directory.
+func (z Zones) PrivateDir(cwd string) bool {
+	if cwd == "" {
+		return false
+	}
+	cwd = filepath.ToSlash(filepath.Clean(cwd))
+	for _, d := range z.dirs {
+		if matchPath(strings.Split(d, "/"), strings.Split(cwd, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// PrivateCommand reports whether a command line matches a private pattern.
+// A pattern like "pass *" also matches the bare "pass".
+func (z Zones) PrivateCommand(command string) bool {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return false
+	}
+	for _, re := range z.commands {
+		if re.MatchString(command) || re.MatchString(command+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocks reports whether a request from cwd for buffer must stay local.
+func (z Zones) Blocks(cwd, buffer string) bool {
+	return z.PrivateDir(cwd) || z.PrivateCommand(buffer)
+}
+
+// FilterHistory drops history lines that match a private command pattern.
+func (z Zones) FilterHistory(history string) string {
+	if len(z.commands) == 0 || history == "" {
+		return history
+	}
+	lines := strings.Split(history, "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if !z.PrivateCommand(l) {
+			kept = append(kept, l)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// FilterProjects drops projects found in a private directory, such as a
+// private parent of the working directory.
+func (z Zones) FilterProjects(projects []ictx.Project) []ictx.Project {
+	if len(z.dirs) == 0 {
+		return projects
+	}
+	var kept []ictx.Project
+	for _, p := range projects {
+		if !z.PrivateDir(p.Dir) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+func matchPath(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(parts); i++ {
+				if matchPath(rest, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); err != nil || !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+func compileCommand(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
+}
@@ -0,0 +1,193 @@
+package redact
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces every secret before context is sent to a model provider.
+const Mask = "[REDACTED]"
+
+const minLiteralLen = 6
+
+// Redaction describes one masked secret by the rule that caught it and its
+// length, so --show-redactions and the daemon log never repeat any of it.
+type Redaction struct {
+	Field  string
+	Kind   string
+	Length int
+}
+
+func (r Redaction) String() string {
+	return fmt.Sprintf("%s: %s (%d chars)", r.Field, r.Kind, r.Length)
+}
+
+type rule struct {
+	kind string
+	re   *regexp.Regexp
+	// group is the submatch holding the secret; 0 masks the whole match.
+	group int
+}
+
+var rules = []rule{
+	{kind: "private key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	{kind: "aws access key", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{kind: "github token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{kind: "groq key", re: regexp.MustCompile(`\bgsk_[A-Za-z0-9]{20,}\b`)},
+	{kind: "anthropic key", re: regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_-]{20,}`)},
+	{kind: "openai key", re: regexp.MustCompile(`\bsk-(?:proj-|or-v1-)?[A-Za-z0-9_-]{20,}`)},
+	{kind: "slack token", re: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{kind: "google api key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{kind: "stripe key", re: regexp.MustCompile(`\b[rs]k_(?:live|test)_[A-Za-z0-9]{16,}\b`)},
+	{kind: "jwt", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{kind: "bearer token", re: regexp.MustCompile(`(?i)\b(?:bearer|basic)\s+([A-Za-z0-9._~+/-]{8,}=*)`), group: 1},
+	{kind: "url password", re: regexp.MustCompile(`[A-Za-z][A-Za-z0-9+.-]*://[^/\s:@]+:([^@\s/]+)@`), group: 1},
+	{kind: "secret assignment", re: regexp.MustCompile(`(?i)\b[A-Z0-9_]*(?:SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)[A-Z0-9_]*\s*[=:]\s*("[^"]*"|'[^']*'|[^\s"']+)`), group: 1},
+	{kind: "secret flag", re: regexp.MustCompile(`(?i)--?(?:password|passwd|token|secret|api-?key)[= ]("[^"]*"|'[^']*'|[^\s"']+)`), group: 1},
+}
+
+var (
+	secretName = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSWORD|PASSWD|API_?KEY|ACCESS_?KEY|PRIVATE_?KEY|CREDENTIALS?)`)
+	candidate  = regexp.MustCompile(`[A-Za-z0-9+_=-]{24,}`)
+)
+
+var (
+	mu       sync.RWMutex
+	literals []string
+)
+
+// AddLiteral registers a known secret value, such as an API key loaded from
+// the config file or .env, so it is masked wherever it appears.
+func AddLiteral(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minLiteralLen {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, l := range literals {
+		if l == value {
+			return
+		}
+	}
+	literals = append(literals, value)
+	// Longest first, so a secret containing another is masked whole.
+	sort.Slice(literals, func(i, j int) bool { return len(literals[i]) > len(literals[j]) })
+}
+
+// AddEnvSecrets registers the values of environment variables whose names
+// look like they hold credentials.
+func AddEnvSecrets() {
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if ok && secretName.MatchString(name) {
+			AddLiteral(value)
+		}
+	}
+}
+
+// String masks secrets in s. field names the piece of context s came from and
+// is only used to label the returned redactions.
+func String(field, s string) (string, []Redaction) {
+	if s == "" {
+		return s, nil
+	}
+	var found []Redaction
+	record := func(kind, secret string) {
+		found = append(found, Redaction{Field: field, Kind: kind, Length: len(strings.Trim(secret, `"'`))})
+	}
+
+	mu.RLock()
+	for _, l := range literals {
+		if strings.Contains(s, l) {
+			record("known secret", l)
+			s = strings.ReplaceAll(s, l, Mask)
+		}
+	}
+	mu.RUnlock()
+
+	for _, r := range rules {
+		s = replace(r, s, record)
+	}
+
+	s = candidate.ReplaceAllStringFunc(s, func(tok string) string {
+		if !highEntropy(tok) {
+			return tok
+		}
+		record("high-entropy string", tok)
+		return Mask
+	})
+
+	return s, found
+}
+
+func replace(r rule, s string, record func(kind, secret string)) string {
+	matches := r.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2*r.group], m[2*r.group+1]
+		if start < 0 || s[start:end] == Mask {
+			continue
+		}
+		record(r.kind, s[start:end])
+		b.WriteString(s[last:start])
+		b.WriteString(Mask)
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// highEntropy reports whether tok looks like a random credential rather than
+// a word, identifier, or commit hash.
+func highEntropy(tok string) bool {
+	var lower, upper, digit bool
+	hex := true
+	for _, c := range tok {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+			if c > 'f' {
+				hex = false
+			}
+		case c >= 'A' && c <= 'Z':
+			upper = true
+			hex = false
+		case c >= '0' && c <= '9':
+			digit = true
+		default:
+			hex = false
+		}
+	}
+	if hex {
+		// Commit hashes are 40 characters; longer hex strings are keys.
+		return len(tok) > 40 && entropy(tok) >= 3.5
+	}
+	if !digit || !(lower || upper) {
+		return false
+	}
+	return entropy(tok) >= 4.0
+}
+
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	for _, c := range s {
+		counts[c]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -2,18 +2,24 @@ package suggest
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/zeke-john/komplete/internal/files"
+	"github.com/zeke-john/komplete/internal/redact"
 )
 
 const (
-	groqEndpoint   = "https://api.groq.com/openai/v1/chat/completions"
-	defaultModel   = "llama-3.1-8b-instant"
-	systemPrompt   = `You are a shell autocomplete engine. Given a partially typed command, predict the full command.
+	groqEndpoint = "https://api.groq.com/openai/v1/chat/completions"
+	defaultModel = "llama-3.1-8b-instant"
+	systemPrompt = `You are a shell autocomplete engine. Given a partially typed command, predict the full command.
 
 ALWAYS complete aggressively. Even from 2 characters, predict the full command with flags and arguments.
 Use the history and cwd to make smart predictions. If they recently ran a command, predict they'll run something related.
@@ -24,14 +30,19 @@ Rules:
 - The completion MUST start with exactly what the user has typed so far
 - Always include likely flags and arguments, never return just a bare command name
 - Be specific: prefer "git push origin main" over "git push"
-- If the user typed part of a path or filename, complete it based on context`
+- If the user typed part of a path or filename, complete it based on context
+- Only use file and directory names that appear in the listings; never invent paths
+- Follow the style of completions they accepted before
+- For git commands, use the branch, upstream and remote names shown
+- Prefer the project's own tasks (npm scripts, make targets and the like) when they fit what is typed`
 	requestTimeout = 3 * time.Second
 )
 
 type Client struct {
-	apiKey     string
-	model      string
-	httpClient *http.Client
+	keys         *keyPool
+	model        string
+	httpClient   *http.Client
+	redactionLog io.Writer
 }
 
 type Option func(*Client)
@@ -42,13 +53,22 @@ func WithHTTPClient(hc *http.Client) Option {
 	}
 }
 
+// WithRedactionLog prints every secret masked from a request to w.
+func WithRedactionLog(w io.Writer) Option {
+	return func(c *Client) {
+		c.redactionLog = w
+	}
+}
+
+// NewClient takes one API key or several separated by commas, which
+// requests rotate across.
 func NewClient(apiKey, model string, opts ...Option) *Client {
 	if model == "" {
 		model = defaultModel
 	}
 	c := &Client{
-		apiKey: apiKey,
-		model:  model,
+		keys:  newKeyPool(apiKey),
+		model: model,
 		httpClient: &http.Client{
 			Timeout: requestTimeout,
 		},
@@ -59,6 +79,57 @@ func NewClient(apiKey, model string, opts ...Option) *Client {
 	return c
 }
 
+// Inherit carries over which of prev's API keys are resting after a rate
+// limit or rejection, for the keys c uses too.
+func (c *Client) Inherit(prev *Client) {
+	c.keys.inherit(prev.keys)
+}
+
+// MaxProjectTasks keeps project tasks from crowding out the rest of the
+// prompt.
+const MaxProjectTasks = 20
+
+// Input is the context sent to the model with a partially typed command.
+type Input struct {
+	Buffer  string
+	CWD     string
+	Shell   string
+	History string
+	// Session is what this terminal ran recently, oldest first.
+	Session  []SessionCommand
+	Listings []files.Listing
+	// Projects lists the projects around CWD and the tasks they define.
+	Projects string
+	// Git describes the repository CWD is in: branch, upstream, recent
+	// commits, stashes and remotes.
+	Git string
+	// Examples are completions the user accepted before, most relevant
+	// first.
+	Examples []Example
+}
+
+// SessionCommand is a command run in the user's current shell session.
+type SessionCommand struct {
+	Command string
+	CWD     string
+	Exit    int
+}
+
+// Example is a buffer the user typed and the completion they took for it.
+type Example struct {
+	Typed    string
+	Accepted string
+}
+
+// Provider names the service behind the client.
+func (c *Client) Provider() string {
+	return "groq"
+}
+
+func (c *Client) Model() string {
+	return c.model
+}
+
 type chatRequest struct {
 	Model       string    `json:"model"`
 	Messages    []message `json:"messages"`
@@ -80,12 +151,13 @@ type chatResponse struct {
 	} `json:"choices"`
 }
 
-func (c *Client) Complete(ctx context.Context, buffer, cwd, shell, historyStr string) (string, error) {
-	if strings.TrimSpace(buffer) == "" {
+func (c *Client) Complete(ctx context.Context, in Input) (string, error) {
+	if strings.TrimSpace(in.Buffer) == "" {
 		return "", nil
 	}
 
-	userPrompt := buildUserPrompt(buffer, cwd, shell, historyStr)
+	sent := c.redact(in)
+	userPrompt := buildUserPrompt(sent)
 
 	body := chatRequest{
 		Model: c.model,
@@ -103,53 +175,211 @@ func (c *Client) Complete(ctx context.Context, buffer, cwd, shell, historyStr st
 		return "", err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, groqEndpoint, bytes.NewReader(jsonBody))
+	result, err := c.post(ctx, jsonBody)
 	if err != nil {
 		return "", err
 	}
+
+	if len(result.Choices) == 0 {
+		return "", nil
+	}
+
+	suggestion := strings.TrimSpace(result.Choices[0].Message.Content)
+	suggestion = cleanSuggestion(suggestion, sent.Buffer)
+	if suggestion == "" || strings.Contains(suggestion[len(sent.Buffer):], redact.Mask) {
+		return "", nil
+	}
+	return in.Buffer + suggestion[len(sent.Buffer):], nil
+}
+
+// post sends the request with the next usable key, moving on to the
+// following key when one is rate limited or rejected.
+func (c *Client) post(ctx context.Context, body []byte) (chatResponse, error) {
+	var result chatResponse
+	if c.keys.size() == 0 {
+		return result, errors.New("no groq api key")
+	}
+	var err error
+	for range c.keys.size() {
+		key, wait, ok := c.keys.get()
+		if !ok {
+			if err == nil {
+				err = &APIError{StatusCode: http.StatusTooManyRequests, RetryAfter: wait, Message: "every API key is resting after a rate limit"}
+			}
+			return result, err
+		}
+		err = c.send(ctx, key, body, &result)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) {
+			return result, err
+		}
+		switch {
+		case apiErr.RateLimited():
+			c.keys.rest(key, cmp.Or(apiErr.RetryAfter, defaultBackoff))
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			c.keys.rest(key, badKeyBackoff)
+		default:
+			return result, err
+		}
+	}
+	return result, err
+}
+
+func (c *Client) send(ctx context.Context, key string, body []byte, result *chatResponse) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, groqEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Authorization", "Bearer "+key)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer resp.Body.Close()
 
+	wait := retryAfter(resp.Header)
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("groq api returned %d", resp.StatusCode)
+		var apiResp struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&apiResp)
+		return &APIError{StatusCode: resp.StatusCode, RetryAfter: wait, Message: apiResp.Error.Message}
 	}
-
-	var result chatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+	if wait > 0 {
+		// This request got through but used up the limit; rest the key
+		// before the provider starts refusing it.
+		c.keys.rest(key, wait)
 	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
 
-	if len(result.Choices) == 0 {
-		return "", nil
+// redact masks secrets in everything the user typed or ran, and in the
+// paths and file names around them, before it leaves the machine. The buffer
+// is restored in Complete's result.
+func (c *Client) redact(in Input) Input {
+	var found, r []redact.Redaction
+	in.Buffer, found = redact.String("buffer", in.Buffer)
+	in.CWD, r = redact.String("cwd", in.CWD)
+	found = append(found, r...)
+	listings := in.Listings
+	in.Listings = make([]files.Listing, len(listings))
+	for i, l := range listings {
+		l.Dir, r = redact.String("listing", l.Dir)
+		found = append(found, r...)
+		names := make([]string, len(l.Names))
+		for j, name := range l.Names {
+			names[j], r = redact.String("listing", name)
+			found = append(found, r...)
+		}
+		l.Names = names
+		in.Listings[i] = l
 	}
-
-	suggestion := strings.TrimSpace(result.Choices[0].Message.Content)
-	suggestion = cleanSuggestion(suggestion, buffer)
-	return suggestion, nil
+	in.History, r = redact.String("history", in.History)
+	found = append(found, r...)
+	in.Projects, r = redact.String("project tasks", in.Projects)
+	found = append(found, r...)
+	in.Git, r = redact.String("git", in.Git)
+	found = append(found, r...)
+	session := in.Session
+	in.Session = make([]SessionCommand, len(session))
+	for i, c := range session {
+		c.Command, r = redact.String("session", c.Command)
+		found = append(found, r...)
+		c.CWD, r = redact.String("session", c.CWD)
+		found = append(found, r...)
+		in.Session[i] = c
+	}
+	examples := in.Examples
+	in.Examples = nil
+	for _, ex := range examples {
+		accepted, r := redact.String("example", ex.Accepted)
+		found = append(found, r...)
+		if len(r) > 0 {
+			continue
+		}
+		typed, _ := redact.String("example", ex.Typed)
+		in.Examples = append(in.Examples, Example{Typed: typed, Accepted: accepted})
+	}
+	if c.redactionLog != nil {
+		for _, r := range found {
+			fmt.Fprintf(c.redactionLog, "redacted %s\n", r)
+		}
+	}
+	return in
 }
 
-func buildUserPrompt(buffer, cwd, shell, historyStr string) string {
+func buildUserPrompt(in Input) string {
 	var b strings.Builder
 	b.WriteString("shell: ")
-	b.WriteString(shell)
+	b.WriteString(in.Shell)
 	b.WriteString("\ncwd: ")
-	b.WriteString(cwd)
-	if historyStr != "" && historyStr != "No shell history available." {
+	b.WriteString(in.CWD)
+	for _, l := range in.Listings {
+		if l.Dir == in.CWD {
+			b.WriteString("\nfiles in cwd: ")
+		} else {
+			b.WriteString("\nfiles in ")
+			b.WriteString(l.Dir)
+			b.WriteString(": ")
+		}
+		b.WriteString(l.String())
+	}
+	if in.Git != "" {
+		b.WriteString("\ngit:\n")
+		for _, l := range strings.Split(in.Git, "\n") {
+			b.WriteString("  ")
+			b.WriteString(l)
+			b.WriteByte('\n')
+		}
+	}
+	if in.Projects != "" {
+		b.WriteString("\nproject tasks:\n")
+		for _, l := range strings.Split(in.Projects, "\n") {
+			b.WriteString("  ")
+			b.WriteString(l)
+			b.WriteByte('\n')
+		}
+	}
+	if in.History != "" && in.History != "No shell history available." {
 		b.WriteString("\nrecent history:\n")
-		for _, l := range strings.Split(historyStr, "\n") {
+		for _, l := range strings.Split(in.History, "\n") {
 			b.WriteString("  ")
 			b.WriteString(l)
 			b.WriteByte('\n')
 		}
 	}
+	if len(in.Session) > 0 {
+		b.WriteString("\nthis terminal's recent commands:\n")
+		for _, c := range in.Session {
+			b.WriteString("  ")
+			b.WriteString(c.Command)
+			if c.Exit != 0 {
+				fmt.Fprintf(&b, "  (exit %d)", c.Exit)
+			}
+			if c.CWD != "" && c.CWD != in.CWD {
+				b.WriteString("  (in ")
+				b.WriteString(c.CWD)
+				b.WriteString(")")
+			}
+			b.WriteByte('\n')
+		}
+	}
+	if len(in.Examples) > 0 {
+		b.WriteString("\ncompletions they accepted before:\n")
+		for _, ex := range in.Examples {
+			b.WriteString("  ")
+			b.WriteString(ex.Typed)
+			b.WriteString(" -> ")
+			b.WriteString(ex.Accepted)
+			b.WriteByte('\n')
+		}
+	}
 	b.WriteString("\n> ")
-	b.WriteString(buffer)
+	b.WriteString(in.Buffer)
 	return b.String()
 }
 
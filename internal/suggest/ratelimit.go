@@ -0,0 +1,142 @@
+package suggest
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultBackoff is how long a rate-limited key rests when the provider
+	// doesn't say.
+	defaultBackoff = 5 * time.Second
+	// badKeyBackoff rests a key the provider rejected.
+	badKeyBackoff = 10 * time.Minute
+)
+
+// APIError is a non-200 answer from the provider. RetryAfter is how long the
+// provider asked us to wait, if it said.
+type APIError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("groq api returned %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("groq api returned %d", e.StatusCode)
+}
+
+// RateLimited reports whether the provider asked us to slow down.
+func (e *APIError) RateLimited() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// Temporary reports whether the request may succeed if retried later.
+func (e *APIError) Temporary() bool {
+	return e.RateLimited() || e.StatusCode >= 500
+}
+
+// retryAfter reads Retry-After, or when a rate limit is used up, the
+// x-ratelimit-reset-* header for it.
+func retryAfter(h http.Header) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(secs * float64(time.Second))
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return time.Until(t)
+		}
+	}
+	var wait time.Duration
+	for _, limit := range []string{"requests", "tokens"} {
+		if strings.TrimSpace(h.Get("X-Ratelimit-Remaining-"+limit)) != "0" {
+			continue
+		}
+		if d, err := time.ParseDuration(h.Get("X-Ratelimit-Reset-" + limit)); err == nil {
+			wait = max(wait, d)
+		}
+	}
+	return wait
+}
+
+// keyPool rotates requests across API keys, skipping keys that are resting
+// after a rate limit or rejection.
+type keyPool struct {
+	mu   sync.Mutex
+	keys []poolKey
+	next int
+}
+
+type poolKey struct {
+	value string
+	until time.Time
+}
+
+// newKeyPool takes one key or several separated by commas.
+func newKeyPool(keys string) *keyPool {
+	p := &keyPool{}
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			p.keys = append(p.keys, poolKey{value: k})
+		}
+	}
+	return p
+}
+
+func (p *keyPool) size() int {
+	return len(p.keys)
+}
+
+// get returns the next usable key, or how long until one is usable.
+func (p *keyPool) get() (string, time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var soonest time.Duration
+	for i := range p.keys {
+		k := &p.keys[(p.next+i)%len(p.keys)]
+		if wait := k.until.Sub(now); wait > 0 {
+			if soonest == 0 || wait < soonest {
+				soonest = wait
+			}
+			continue
+		}
+		p.next = (p.next + i + 1) % len(p.keys)
+		return k.value, 0, true
+	}
+	return "", soonest, false
+}
+
+// rest keeps key out of rotation for d.
+func (p *keyPool) rest(key string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.keys {
+		if p.keys[i].value == key {
+			p.keys[i].until = time.Now().Add(d)
+		}
+	}
+}
+
+// inherit keeps resting the keys prev still has resting, so reloading the
+// config doesn't hand a rate-limited or rejected key straight back out.
+func (p *keyPool) inherit(prev *keyPool) {
+	prev.mu.Lock()
+	until := make(map[string]time.Time, len(prev.keys))
+	for _, k := range prev.keys {
+		until[k.value] = k.until
+	}
+	prev.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.keys {
+		p.keys[i].until = until[p.keys[i].value]
+	}
+}
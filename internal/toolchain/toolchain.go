@@ -0,0 +1,340 @@
+// Package toolchain takes stock of the developer tools installed on the
+// machine, so a plan can use the package manager and tools that are there.
+package toolchain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cacheTTL = 24 * time.Hour
+	// versionTimeout bounds each tool's --version; a tool that takes
+	// longer is listed without one.
+	versionTimeout = time.Second
+	cacheFormat    = 1
+)
+
+// Kinds of tools, in the order they're listed.
+const (
+	KindPackageManager = "package managers"
+	KindContainer      = "containers"
+	KindVersionManager = "version managers"
+	KindLanguage       = "languages"
+	KindModern         = "modern tools"
+)
+
+var kinds = []string{KindPackageManager, KindContainer, KindVersionManager, KindLanguage, KindModern}
+
+// Tool is an installed tool. Command is what runs it when that isn't Name,
+// like fdfind for fd on Debian.
+type Tool struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Command string `json:"command,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Inventory is what's installed, and which OS release it's on.
+type Inventory struct {
+	Format      int       `json:"format"`
+	OS          string    `json:"os,omitempty"`
+	Tools       []Tool    `json:"tools"`
+	Fingerprint uint64    `json:"fingerprint"`
+	Checked     time.Time `json:"checked"`
+}
+
+type known struct {
+	name string
+	kind string
+	// commands are the names the tool may be installed as, preferred first.
+	commands []string
+	// version is the arguments that print its version, --version if nil.
+	version []string
+}
+
+var knownTools = []known{
+	{name: "apt", kind: KindPackageManager},
+	{name: "dnf", kind: KindPackageManager},
+	{name: "yum", kind: KindPackageManager},
+	{name: "pacman", kind: KindPackageManager},
+	{name: "zypper", kind: KindPackageManager},
+	{name: "apk", kind: KindPackageManager},
+	{name: "brew", kind: KindPackageManager},
+	{name: "port", kind: KindPackageManager, version: []string{"version"}},
+	{name: "nix", kind: KindPackageManager},
+	{name: "snap", kind: KindPackageManager},
+	{name: "flatpak", kind: KindPackageManager},
+
+	{name: "docker", kind: KindContainer},
+	{name: "podman", kind: KindContainer},
+	{name: "nerdctl", kind: KindContainer},
+	{name: "kubectl", kind: KindContainer, version: []string{"version", "--client"}},
+	{name: "helm", kind: KindContainer, version: []string{"version", "--short"}},
+
+	{name: "pyenv", kind: KindVersionManager},
+	{name: "asdf", kind: KindVersionManager, version: []string{"version"}},
+	{name: "mise", kind: KindVersionManager},
+	{name: "rbenv", kind: KindVersionManager},
+	{name: "fnm", kind: KindVersionManager},
+	{name: "volta", kind: KindVersionManager},
+
+	{name: "node", kind: KindLanguage},
+	{name: "npm", kind: KindLanguage},
+	{name: "pnpm", kind: KindLanguage},
+	{name: "yarn", kind: KindLanguage},
+	{name: "bun", kind: KindLanguage},
+	{name: "python3", kind: KindLanguage},
+	{name: "uv", kind: KindLanguage},
+	{name: "go", kind: KindLanguage, version: []string{"version"}},
+	{name: "cargo", kind: KindLanguage},
+	{name: "java", kind: KindLanguage, version: []string{"-version"}},
+	{name: "ruby", kind: KindLanguage},
+
+	{name: "rg", kind: KindModern},
+	{name: "fd", kind: KindModern, commands: []string{"fd", "fdfind"}},
+	{name: "bat", kind: KindModern, commands: []string{"bat", "batcat"}},
+	{name: "eza", kind: KindModern},
+	{name: "exa", kind: KindModern},
+	{name: "fzf", kind: KindModern},
+	{name: "jq", kind: KindModern},
+	{name: "yq", kind: KindModern},
+	{name: "delta", kind: KindModern},
+	{name: "zoxide", kind: KindModern},
+	{name: "gh", kind: KindModern},
+	{name: "btop", kind: KindModern},
+	{name: "dust", kind: KindModern},
+}
+
+// CachePath is where the inventory is kept between runs.
+func CachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "komplete", "tools.json"), nil
+}
+
+// Load returns the cached inventory for pathEnv, taking stock again when
+// the cache is a day old or a directory on the PATH has changed, as it does
+// when a tool is installed or removed.
+func Load(pathEnv string) Inventory {
+	if path, err := CachePath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			var inv Inventory
+			if json.Unmarshal(data, &inv) == nil && inv.Format == cacheFormat &&
+				inv.Fingerprint == pathFingerprint(pathEnv) && time.Since(inv.Checked) < cacheTTL {
+				return inv
+			}
+		}
+	}
+
+	return Refresh(pathEnv)
+}
+
+// Refresh takes stock of the tools on pathEnv and caches what it finds.
+func Refresh(pathEnv string) Inventory {
+	inv := Detect(pathEnv)
+	inv.Fingerprint = pathFingerprint(pathEnv)
+	if path, err := CachePath(); err == nil {
+		save(path, inv)
+	}
+	return inv
+}
+
+func save(path string, inv Inventory) {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tools-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return
+	}
+	if tmp.Close() == nil {
+		os.Rename(tmp.Name(), path)
+	}
+}
+
+// pathFingerprint hashes the directories on pathEnv and when each last
+// changed.
+func pathFingerprint(pathEnv string) uint64 {
+	h := fnv.New64a()
+	for _, dir := range filepath.SplitList(pathEnv) {
+		h.Write([]byte(dir))
+		if info, err := os.Stat(dir); err == nil {
+			h.Write([]byte(strconv.FormatInt(info.ModTime().UnixNano(), 10)))
+		}
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// Detect takes stock of the tools on pathEnv, asking each for its version
+// at the same time.
+func Detect(pathEnv string) Inventory {
+	inv := Inventory{Format: cacheFormat, OS: osRelease(), Checked: time.Now()}
+	found := make([]*Tool, len(knownTools))
+	var wg sync.WaitGroup
+	for i, k := range knownTools {
+		commands := k.commands
+		if commands == nil {
+			commands = []string{k.name}
+		}
+		for _, command := range commands {
+			bin := lookPath(command, pathEnv)
+			if bin == "" {
+				continue
+			}
+			tool := &Tool{Name: k.name, Kind: k.kind}
+			if command != k.name {
+				tool.Command = command
+			}
+			found[i] = tool
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tool.Version = version(bin, k.version)
+			}()
+			break
+		}
+	}
+	wg.Wait()
+
+	for _, t := range found {
+		if t != nil {
+			inv.Tools = append(inv.Tools, *t)
+		}
+	}
+	inv.Tools = append(inv.Tools, shellManagers()...)
+	return inv
+}
+
+func lookPath(name, pathEnv string) string {
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0o111 != 0 {
+			return path
+		}
+	}
+	return ""
+}
+
+// shellManagers finds version managers that live in the shell rather than
+// on the PATH.
+func shellManagers() []Tool {
+	home, _ := os.UserHomeDir()
+	var tools []Tool
+	for _, m := range []struct{ name, env, dir, script string }{
+		{"nvm", "NVM_DIR", ".nvm", "nvm.sh"},
+		{"sdkman", "SDKMAN_DIR", ".sdkman", "bin/sdkman-init.sh"},
+	} {
+		dir := os.Getenv(m.env)
+		if dir == "" && home != "" {
+			dir = filepath.Join(home, m.dir)
+		}
+		if _, err := os.Stat(filepath.Join(dir, m.script)); err == nil {
+			tools = append(tools, Tool{Name: m.name, Kind: KindVersionManager})
+		}
+	}
+	return tools
+}
+
+var versionPattern = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// version runs bin with args, --version by default, and picks the first
+// version number out of what it prints.
+func version(bin string, args []string) string {
+	if args == nil {
+		args = []string{"--version"}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, bin, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	// java prints its version on stderr.
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil && out.Len() == 0 {
+		return ""
+	}
+	return versionPattern.FindString(out.String())
+}
+
+// osRelease is the Linux distribution's name from /etc/os-release, or ""
+// elsewhere.
+func osRelease() string {
+	file, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'`)
+		}
+		fields[key] = value
+	}
+	if name := fields["PRETTY_NAME"]; name != "" {
+		return name
+	}
+	return strings.TrimSpace(fields["NAME"] + " " + fields["VERSION_ID"])
+}
+
+// String lists the inventory for a prompt, one line per kind of tool.
+func (inv Inventory) String() string {
+	var b strings.Builder
+	if inv.OS != "" {
+		b.WriteString("OS release: " + inv.OS + "\n")
+	}
+	for _, kind := range kinds {
+		var names []string
+		for _, t := range inv.Tools {
+			if t.Kind != kind {
+				continue
+			}
+			name := t.Name
+			if t.Command != "" {
+				name += " (run as " + t.Command + ")"
+			}
+			if t.Version != "" {
+				name += " " + t.Version
+			}
+			names = append(names, name)
+		}
+		if len(names) > 0 {
+			b.WriteString(kind + ": " + strings.Join(names, ", ") + "\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,87 @@
+// Package watch notifies when a file changes. It uses inotify on Linux and
+// kqueue on macOS and FreeBSD, and falls back to polling the file's metadata
+// elsewhere.
+package watch
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+const pollInterval = time.Second
+
+// Watcher sends on C after the file is written, created, replaced or
+// removed. Bursts of changes are coalesced into one send.
+type Watcher struct {
+	C <-chan struct{}
+
+	c         chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeFn   func()
+}
+
+// File watches path, which need not exist yet.
+func File(path string) *Watcher {
+	c := make(chan struct{}, 1)
+	w := &Watcher{C: c, c: c, done: make(chan struct{})}
+	if !w.native(path) {
+		go w.poll(path)
+	}
+	return w
+}
+
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		if w.closeFn != nil {
+			w.closeFn()
+		}
+	})
+}
+
+func (w *Watcher) notify() {
+	select {
+	case w.c <- struct{}{}:
+	default:
+	}
+}
+
+type fileState struct {
+	info os.FileInfo
+	err  error
+}
+
+func stat(path string) fileState {
+	info, err := os.Stat(path)
+	return fileState{info: info, err: err}
+}
+
+func (s fileState) changed(prev fileState) bool {
+	if (s.err == nil) != (prev.err == nil) {
+		return true
+	}
+	if s.err != nil {
+		return false
+	}
+	return !os.SameFile(s.info, prev.info) || s.info.Size() != prev.info.Size() || !s.info.ModTime().Equal(prev.info.ModTime())
+}
+
+func (w *Watcher) poll(path string) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	prev := stat(path)
+	for {
+		select {
+		case <-ticker.C:
+			cur := stat(path)
+			if cur.changed(prev) {
+				w.notify()
+			}
+			prev = cur
+		case <-w.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+//go:build darwin || freebsd
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	fileNotes = unix.NOTE_WRITE | unix.NOTE_EXTEND | unix.NOTE_ATTRIB | unix.NOTE_DELETE | unix.NOTE_RENAME
+	dirNotes  = unix.NOTE_WRITE | unix.NOTE_DELETE | unix.NOTE_RENAME
+)
+
+// kqueue is a kernel event queue that Close wakes with a user event.
+type kqueue struct {
+	fd int
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// native watches the file and its directory, so editors that save by
+// renaming a new file over the old one are still seen. It reports false
+// when kqueue can't be used and the caller should poll instead.
+func (w *Watcher) native(path string) bool {
+	fd, err := unix.Kqueue()
+	if err != nil {
+		return false
+	}
+	unix.CloseOnExec(fd)
+	dir, err := unix.Open(filepath.Dir(path), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		unix.Close(fd)
+		return false
+	}
+	var wake unix.Kevent_t
+	wake.Ident, wake.Filter, wake.Flags = 0, unix.EVFILT_USER, unix.EV_ADD|unix.EV_CLEAR
+	if _, err := unix.Kevent(fd, []unix.Kevent_t{vnode(dir, dirNotes), wake}, nil, nil); err != nil {
+		unix.Close(dir)
+		unix.Close(fd)
+		return false
+	}
+	kq := &kqueue{fd: fd}
+	w.closeFn = kq.wake
+	go w.read(kq, dir, path)
+	return true
+}
+
+func vnode(fd int, notes uint32) unix.Kevent_t {
+	var ev unix.Kevent_t
+	unix.SetKevent(&ev, fd, unix.EVFILT_VNODE, unix.EV_ADD|unix.EV_CLEAR)
+	ev.Fflags = notes
+	return ev
+}
+
+func (kq *kqueue) wake() {
+	kq.mu.Lock()
+	defer kq.mu.Unlock()
+	if kq.closed {
+		return
+	}
+	var ev unix.Kevent_t
+	ev.Ident, ev.Filter, ev.Fflags = 0, unix.EVFILT_USER, unix.NOTE_TRIGGER
+	unix.Kevent(kq.fd, []unix.Kevent_t{ev}, nil, nil)
+}
+
+func (kq *kqueue) close() {
+	kq.mu.Lock()
+	defer kq.mu.Unlock()
+	kq.closed = true
+	unix.Close(kq.fd)
+}
+
+func (w *Watcher) read(kq *kqueue, dir int, path string) {
+	defer kq.close()
+	defer unix.Close(dir)
+
+	// The file is watched through its own descriptor, which has to be
+	// opened again whenever the path comes to name another file.
+	file := -1
+	watchFile := func() {
+		if file >= 0 {
+			unix.Close(file)
+			file = -1
+		}
+		fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return
+		}
+		if _, err := unix.Kevent(kq.fd, []unix.Kevent_t{vnode(fd, fileNotes)}, nil, nil); err != nil {
+			unix.Close(fd)
+			return
+		}
+		file = fd
+	}
+	watchFile()
+	defer func() {
+		if file >= 0 {
+			unix.Close(file)
+		}
+	}()
+
+	prev := stat(path)
+	events := make([]unix.Kevent_t, 8)
+	for {
+		n, err := unix.Kevent(kq.fd, nil, events, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		written, gone := false, false
+		for _, ev := range events[:n] {
+			switch {
+			case ev.Filter == unix.EVFILT_USER:
+				return
+			case int(ev.Ident) == dir && ev.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0:
+				// The directory itself went away.
+				w.notify()
+				go w.poll(path)
+				return
+			case int(ev.Ident) == file:
+				written = true
+				gone = gone || ev.Fflags&(unix.NOTE_DELETE|unix.NOTE_RENAME) != 0
+			}
+		}
+
+		// A change to the directory may be another file's.
+		cur := stat(path)
+		if written || cur.changed(prev) {
+			w.notify()
+		}
+		if gone || file < 0 || (cur.err == nil && (prev.err != nil || !os.SameFile(cur.info, prev.info))) {
+			watchFile()
+		}
+		prev = cur
+	}
+}
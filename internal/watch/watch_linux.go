@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_MODIFY | unix.IN_CLOSE_WRITE | unix.IN_ATTRIB |
+	unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM
+
+// native watches the file's directory, so editors that save by renaming a
+// new file over the old one are still seen. It reports false when inotify
+// can't be used and the caller should poll instead.
+func (w *Watcher) native(path string) bool {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return false
+	}
+	if _, err := unix.InotifyAddWatch(fd, filepath.Dir(path), inotifyMask); err != nil {
+		unix.Close(fd)
+		return false
+	}
+	// A nonblocking fd wrapped in os.File uses the runtime poller, so Close
+	// unblocks the pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	w.closeFn = func() { f.Close() }
+	go w.read(f, path)
+	return true
+}
+
+func (w *Watcher) read(f *os.File, path string) {
+	name := filepath.Base(path)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+			if ev.Mask&unix.IN_IGNORED != 0 {
+				// The directory itself went away.
+				w.notify()
+				go w.poll(path)
+				return
+			}
+			if cString(nameBytes) == name {
+				w.notify()
+			}
+		}
+	}
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin && !freebsd
+
+package watch
+
+func (w *Watcher) native(path string) bool {
+	return false
+}
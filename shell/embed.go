@@ -4,3 +4,9 @@ import _ "embed"
 
 //go:embed komplete.zsh
 var ZshScript string
+
+//go:embed komplete.bash
+var BashScript string
+
+//go:embed komplete.fish
+var FishScript string
@@ -0,0 +1,393 @@
+if [[ -z "$BASH_VERSION" || $- != *i* || "$TERM" == "dumb" ]]; then
+    # not interactive bash; skip
+    :
+elif (( BASH_VERSINFO[0] < 4 || (BASH_VERSINFO[0] == 4 && BASH_VERSINFO[1] < 4) )); then
+    # bind -x can't edit the line before bash 4, and $! isn't set for a
+    # process substitution before 4.4
+    echo "komplete: autocomplete needs bash 4.4 or newer, this is $BASH_VERSION; only the k alias is set up" >&2
+    alias k=komplete
+else
+
+_komplete_suggestion=""
+_komplete_bin="${KOMPLETE_BIN:-komplete}"
+_komplete_min_chars="${KOMPLETE_MIN_CHARS:-2}"
+# The pipe to this shell's `komplete query --stream` helper, the helper's
+# pid, and whether a request sent on it may still be running.
+_komplete_stream="" _komplete_stream_pid=""
+_komplete_pending=0
+# The aliases and functions the daemon was last told about.
+_komplete_names=""
+_komplete_prompt_width=""
+_komplete_daemon_ready=0
+# Must match config.RuntimeDir: a 0700 directory only we can reach.
+if [[ -n "$XDG_RUNTIME_DIR" ]]; then
+    _komplete_runtime_dir="$XDG_RUNTIME_DIR/komplete"
+else
+    _komplete_runtime_dir="${TMPDIR:-/tmp}"
+    _komplete_runtime_dir="${_komplete_runtime_dir%/}/komplete-$UID"
+fi
+command mkdir -p -m 700 "$_komplete_runtime_dir" 2>/dev/null
+_komplete_socket="$_komplete_runtime_dir/daemon.sock"
+_komplete_pidfile="$_komplete_runtime_dir/daemon.pid"
+_komplete_result_file="$_komplete_runtime_dir/result-$$"
+# The line as of the last key we saw, so a suggestion that arrives late is
+# only drawn if it still fits.
+_komplete_line_file="$_komplete_runtime_dir/line-$$"
+_komplete_state_dir="${XDG_STATE_HOME:-$HOME/.local/state}/komplete"
+# The last suggestion shown, the line it was shown for, what a word-by-word
+# accept has taken of it, and whether the daemon has heard what happened.
+_komplete_shown="" _komplete_shown_buffer="" _komplete_shown_taken=""
+_komplete_shown_reported=0
+_komplete_last_command="" _komplete_last_cwd="" _komplete_last_start=""
+
+# Identifies this shell to `komplete pause` and the daemon.
+export KOMPLETE_SESSION=$$
+
+_komplete_daemon_alive() {
+    local pid
+    [[ -S "$_komplete_socket" && -r "$_komplete_pidfile" ]] || return 1
+    pid=$(<"$_komplete_pidfile")
+    kill -0 "$pid" 2>/dev/null
+}
+
+_komplete_ensure_daemon() {
+    # Refuse a runtime dir someone else created for us.
+    [[ -d "$_komplete_runtime_dir" && -O "$_komplete_runtime_dir" ]] || return 1
+
+    # The daemon exits on its own when idle, removing its socket and pidfile.
+    if (( _komplete_daemon_ready )) && _komplete_daemon_alive; then
+        return 0
+    fi
+    _komplete_daemon_ready=0
+
+    if ! _komplete_daemon_alive; then
+        ( "$_komplete_bin" daemon --socket "$_komplete_socket" &>/dev/null & )
+
+        local i=0
+        while (( i++ < 20 )) && [[ ! -S "$_komplete_socket" ]]; do
+            sleep 0.05
+        done
+        [[ -S "$_komplete_socket" ]] || return 1
+    fi
+
+    _komplete_daemon_ready=1
+}
+
+# Tells the daemon what happened to the last suggestion shown:
+# _komplete_report accept|partial|ignore
+_komplete_report() {
+    [[ -n "$_komplete_shown" ]] && (( ! _komplete_shown_reported )) || return
+    _komplete_shown_reported=1
+    ( "$_komplete_bin" query --socket "$_komplete_socket" --type feedback --cwd "$PWD" \
+        --event "$1" --suggestion "$_komplete_shown" --accepted "$_komplete_shown_taken" \
+        -- "$_komplete_shown_buffer" &>/dev/null & )
+}
+
+# Sets _komplete_prompt_width to the width of the prompt's last line.
+_komplete_measure_prompt() {
+    local p=""
+    (( BASH_VERSINFO[0] > 4 || BASH_VERSINFO[1] >= 4 )) && p=${PS1@P}
+    p=${p##*$'\n'}
+    # Drop the \[...\] spans, which readline marks with \001 and \002.
+    while [[ "$p" == *$'\001'*$'\002'* ]]; do
+        p=${p%%$'\001'*}${p#*$'\002'}
+    done
+    _komplete_prompt_width=${#p}
+}
+
+# Records the line being edited, with the prompt width and terminal columns
+# the helper needs to draw after it, since it only has the copies of our
+# variables made when it started.
+_komplete_save_line() {
+    [[ -n "$_komplete_prompt_width" ]] || _komplete_measure_prompt
+    printf '%s %s\n%s' "$_komplete_prompt_width" "${COLUMNS:-80}" "$READLINE_LINE" > "$_komplete_line_file"
+}
+
+# Draws the rest of suggestion $1 after the cursor in grey, if it continues
+# the line last saved. Readline knows nothing of it; the next key redraws
+# the line.
+_komplete_paint() {
+    local suggestion=$1 state width cols line rest room
+    [[ -r "$_komplete_line_file" ]] && state=$(<"$_komplete_line_file")
+    [[ "$state" == *$'\n'* ]] || return
+    read -r width cols <<< "${state%%$'\n'*}"
+    line=${state#*$'\n'}
+    [[ -n "$line" && "$suggestion" == "$line"* && "$suggestion" != "$line" ]] || return
+    rest=${suggestion#"$line"}
+    rest=${rest%%$'\n'*}
+    # Stay on this row; the saved cursor is lost if the terminal scrolls.
+    room=$(( cols - 1 - (width + ${#line}) % cols ))
+    (( room > 0 )) || return
+    printf '\e7\e[K\e[90m%s\e[0m\e8' "${rest:0:room}" >/dev/tty 2>/dev/null
+}
+
+# Starts the helper that keeps this shell's connection to the daemon. The
+# daemon may answer a request more than once: a history match first, then a
+# final response with the model's suggestion. Each answer goes to the result
+# file and is drawn if it still fits the line.
+_komplete_start_stream() {
+    local bin=$_komplete_bin sock=$_komplete_socket rfile=$_komplete_result_file
+    exec {_komplete_stream}> >(
+        "$bin" query --stream -0 --socket "$sock" --shell bash --parent $$ 2>/dev/null | while IFS= read -r -d '' suggestion; do
+            printf '%s\n' "$suggestion" > "$rfile"
+            # Give readline a moment to redraw the line before drawing over it.
+            sleep 0.01
+            _komplete_paint "$suggestion"
+        done
+    ) 2>/dev/null
+    _komplete_stream_pid=$!
+}
+
+# Sends the helper one request as key=value fields, starting another helper
+# if it has gone away: writing to a helper that exited would kill the shell.
+_komplete_send() {
+    if [[ -z "$_komplete_stream" ]] || ! kill -0 "$_komplete_stream_pid" 2>/dev/null; then
+        [[ -n "$_komplete_stream" ]] && exec {_komplete_stream}>&-
+        _komplete_start_stream
+    fi
+    printf '%s\0' "$@" '' >&"$_komplete_stream" 2>/dev/null
+}
+
+# Tells the daemon this shell's aliases and functions when they change,
+# since it can't see them itself.
+_komplete_send_names() {
+    local names
+    names=$(compgen -a -A function)
+    [[ "$names" == "$_komplete_names" ]] && return
+    _komplete_names=$names
+    _komplete_send type=names "names=$names"
+}
+
+# Cancels the request in flight, if any, and drops an answer not yet seen.
+_komplete_cancel() {
+    if (( _komplete_pending )); then
+        _komplete_send type=cancel
+        _komplete_pending=0
+    fi
+    command rm -f "$_komplete_result_file" 2>/dev/null
+}
+
+_komplete_paused() {
+    [[ -e "$_komplete_state_dir/paused" || -e "$_komplete_state_dir/paused-$KOMPLETE_SESSION" ]]
+}
+
+# Asks for a suggestion for the line, superseding the last request.
+_komplete_query_daemon() {
+    _komplete_ensure_daemon || return
+    _komplete_send type=complete "buffer=$READLINE_LINE" "cwd=$PWD" "path=$PATH" "histfile=$HISTFILE"
+    _komplete_pending=1
+}
+
+# Picks up a suggestion the background query left behind.
+_komplete_apply_result() {
+    [[ -s "$_komplete_result_file" ]] || return
+    local suggestion
+    suggestion=$(<"$_komplete_result_file")
+    [[ -n "$suggestion" ]] || return
+    _komplete_suggestion=$suggestion
+    if [[ "$suggestion" != "$_komplete_shown" ]]; then
+        _komplete_shown=$suggestion
+        _komplete_shown_buffer=$READLINE_LINE
+        _komplete_shown_taken=""
+        _komplete_shown_reported=0
+    fi
+}
+
+# Reports whether the current suggestion continues the line at the cursor.
+_komplete_showing() {
+    _komplete_apply_result
+    [[ -n "$READLINE_LINE" && -n "$_komplete_suggestion" ]] &&
+        (( READLINE_POINT == ${#READLINE_LINE} )) &&
+        [[ "$_komplete_suggestion" == "$READLINE_LINE"* && "$_komplete_suggestion" != "$READLINE_LINE" ]]
+}
+
+# Runs after every key that edits the line: keeps showing a suggestion the
+# user is typing along, or asks the daemon for a new one.
+_komplete_changed() {
+    if (( READLINE_POINT != ${#READLINE_LINE} )); then
+        : > "$_komplete_line_file"
+        return
+    fi
+    _komplete_save_line
+
+    if _komplete_showing; then
+        { ( sleep 0.01; _komplete_paint "$_komplete_suggestion" ) & } 2>/dev/null
+        disown $! 2>/dev/null
+        return
+    fi
+
+    _komplete_suggestion=""
+    _komplete_cancel
+    (( ${#READLINE_LINE} < _komplete_min_chars )) && return
+    [[ "$READLINE_LINE" == cd\ * || "$READLINE_LINE" == "cd" ]] && return
+    _komplete_paused && return
+
+    _komplete_query_daemon
+}
+
+_komplete_self_insert() {
+    READLINE_LINE="${READLINE_LINE:0:READLINE_POINT}$1${READLINE_LINE:READLINE_POINT}"
+    (( READLINE_POINT += ${#1} ))
+    _komplete_changed
+}
+
+# The accept keys fall back to what they did before when there is nothing
+# to accept. A bind -x command can't run a readline function itself, so
+# each key is a macro that runs ours and then a spare key sequence, which
+# we bind to the fallback or to nothing.
+_komplete_fallback() {
+    bind -m "$_komplete_keymap" "\"\\C-x\\C-k$1\": ${2:-redraw-current-line}"
+}
+
+_komplete_accept() {
+    if _komplete_showing; then
+        _komplete_report accept
+        _komplete_cancel
+        READLINE_LINE=$_komplete_suggestion
+        READLINE_POINT=${#READLINE_LINE}
+        _komplete_suggestion=""
+        : > "$_komplete_line_file"
+        _komplete_fallback 1
+    else
+        _komplete_fallback 1 "$_komplete_tab_fallback"
+    fi
+}
+
+_komplete_accept_word() {
+    if _komplete_showing; then
+        local remaining=${_komplete_suggestion#"$READLINE_LINE"} next_word
+        if [[ "$remaining" == *" "* ]]; then
+            next_word="${remaining%% *} "
+        else
+            next_word=$remaining
+        fi
+        READLINE_LINE+=$next_word
+        READLINE_POINT=${#READLINE_LINE}
+        if [[ "$READLINE_LINE" == "$_komplete_shown" ]]; then
+            _komplete_report accept
+        else
+            _komplete_shown_taken=$READLINE_LINE
+        fi
+        _komplete_changed
+        _komplete_fallback 2
+    else
+        _komplete_fallback 2 "$1"
+    fi
+}
+
+_komplete_accept_line() {
+    if [[ -n "$_komplete_shown_taken" ]]; then
+        _komplete_report partial
+    elif [[ -n "$_komplete_shown" && "$READLINE_LINE" != "$_komplete_shown" ]]; then
+        _komplete_report ignore
+    fi
+    _komplete_cancel
+    : > "$_komplete_line_file"
+    _komplete_suggestion=""
+    _komplete_last_command=$READLINE_LINE
+    _komplete_last_cwd=$PWD
+    _komplete_now; _komplete_last_start=$REPLY
+}
+
+# Sets REPLY to the time in microseconds, to the second before bash 5.
+_komplete_now() {
+    if [[ -n "$EPOCHREALTIME" ]]; then
+        REPLY=${EPOCHREALTIME//[!0-9]/}
+    else
+        REPLY=$(( SECONDS * 1000000 ))
+    fi
+}
+
+# Sets REPLY to the readline function bound to key $1 in the current keymap.
+_komplete_bound() {
+    local line
+    REPLY=""
+    while IFS= read -r line; do
+        if [[ "$line" == "\"$1\": "* ]]; then
+            REPLY=${line#"\"$1\": "}
+            return
+        fi
+    done < <(bind -m "$_komplete_keymap" -p 2>/dev/null)
+}
+
+_komplete_bind() {
+    local c key hex i forward_word
+    _komplete_bound '\C-i'; _komplete_tab_fallback=${REPLY:-complete}
+    _komplete_bound '\ef'; forward_word=${REPLY:-forward-word}
+
+    for (( i = 32; i < 127; i++ )); do
+        printf -v hex %x "$i"
+        printf -v c "\\x$hex"
+        key=$c
+        [[ "$c" == '"' || "$c" == '\' ]] && key="\\$c"
+        bind -m "$_komplete_keymap" -x "\"$key\": _komplete_self_insert '${c//\'/\'\\\'\'}'"
+    done
+    # Backspace and the other editing keys stay readline's. They clear to
+    # the end of the line, which takes the suggestion with it.
+
+    bind -m "$_komplete_keymap" -x "\"\\C-x\\C-ka\": _komplete_accept"
+    bind -m "$_komplete_keymap" -x "\"\\C-x\\C-kb\": _komplete_accept_word $forward_word"
+    bind -m "$_komplete_keymap" -x "\"\\C-x\\C-kc\": _komplete_accept_word redraw-current-line"
+    bind -m "$_komplete_keymap" -x "\"\\C-x\\C-kr\": _komplete_accept_line"
+    bind -m "$_komplete_keymap" "\"\\C-x\\C-ke\": accept-line"
+    _komplete_fallback 1 "$_komplete_tab_fallback"
+    _komplete_fallback 2 "$forward_word"
+
+    bind -m "$_komplete_keymap" '"\C-i": "\C-x\C-ka\C-x\C-k1"'
+    bind -m "$_komplete_keymap" '"\e[Z": "\C-x\C-kc\C-x\C-k2"'
+    bind -m "$_komplete_keymap" '"\ef": "\C-x\C-kb\C-x\C-k2"'
+    bind -m "$_komplete_keymap" '"\C-m": "\C-x\C-kr\C-x\C-ke"'
+    bind -m "$_komplete_keymap" '"\C-j": "\C-x\C-kr\C-x\C-ke"'
+}
+
+# The keymap our keys go into, where _komplete_fallback rebinds them later.
+_komplete_keymap=emacs
+if [[ -o vi ]]; then
+    _komplete_keymap=vi-insert
+fi
+_komplete_bind
+
+# Reports the command that just finished, so suggestions see it before bash
+# writes it to the history file.
+_komplete_precmd() {
+    local exit_status=$?
+    if [[ -n "$_komplete_last_command" ]] && ! _komplete_paused; then
+        local ms=0
+        if [[ -n "$_komplete_last_start" ]]; then
+            _komplete_now; ms=$(( (REPLY - _komplete_last_start) / 1000 ))
+        fi
+        ( "$_komplete_bin" query --socket "$_komplete_socket" --type command --cwd "$_komplete_last_cwd" \
+            --command "$_komplete_last_command" --exit $exit_status --duration ${ms}ms &>/dev/null & )
+    fi
+    _komplete_last_command=""
+    _komplete_suggestion=""
+    _komplete_prompt_width=""
+    _komplete_shown=""
+    _komplete_shown_taken=""
+    _komplete_cancel
+    _komplete_send_names
+    return $exit_status
+}
+
+# Runs first so it sees the command's exit status.
+if [[ "$(declare -p PROMPT_COMMAND 2>/dev/null)" == "declare -a"* ]]; then
+    PROMPT_COMMAND=(_komplete_precmd "${PROMPT_COMMAND[@]}")
+else
+    PROMPT_COMMAND="_komplete_precmd${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+
+_komplete_cleanup() {
+    _komplete_cancel
+    [[ -n "$_komplete_stream" ]] && exec {_komplete_stream}>&-
+    command rm -f "$_komplete_line_file" "$_komplete_state_dir/paused-$KOMPLETE_SESSION" 2>/dev/null
+}
+# Keep whatever EXIT trap the user already has.
+eval "set -- $(trap -p EXIT)"
+trap "_komplete_cleanup${3:+; $3}" EXIT
+set --
+
+_komplete_ensure_daemon
+
+alias k=komplete
+
+fi
@@ -0,0 +1,448 @@
+if status is-interactive; and test "$TERM" != dumb
+
+set -g _komplete_suggestion ""
+set -g _komplete_bin komplete
+set -q KOMPLETE_BIN; and set _komplete_bin $KOMPLETE_BIN
+set -g _komplete_min_chars 2
+set -q KOMPLETE_MIN_CHARS; and set _komplete_min_chars $KOMPLETE_MIN_CHARS
+# This shell's `komplete query --stream` helper, the fifo it reads requests
+# from, and whether a request sent to it may still be running.
+set -g _komplete_stream_pid ""
+set -g _komplete_pending 0
+# The functions and abbreviations the daemon was last told about.
+set -g _komplete_names ""
+# Must match config.RuntimeDir: a 0700 directory only we can reach.
+if test -n "$XDG_RUNTIME_DIR"
+    set -g _komplete_runtime_dir $XDG_RUNTIME_DIR/komplete
+else
+    set -l tmp /tmp
+    test -n "$TMPDIR"; and set tmp (string replace -r '/$' '' -- $TMPDIR)
+    set -g _komplete_runtime_dir $tmp/komplete-(id -u)
+end
+command mkdir -p -m 700 $_komplete_runtime_dir 2>/dev/null
+set -g _komplete_socket $_komplete_runtime_dir/daemon.sock
+set -g _komplete_pidfile $_komplete_runtime_dir/daemon.pid
+set -g _komplete_result_file $_komplete_runtime_dir/result-$fish_pid
+set -g _komplete_fifo $_komplete_runtime_dir/stream-$fish_pid
+set -g _komplete_state_dir $HOME/.local/state/komplete
+test -n "$XDG_STATE_HOME"; and set _komplete_state_dir $XDG_STATE_HOME/komplete
+# The last suggestion shown, the line it was shown for, what a word-by-word
+# accept has taken of it, and whether the daemon has heard what happened.
+set -g _komplete_shown ""
+set -g _komplete_shown_buffer ""
+set -g _komplete_shown_taken ""
+set -g _komplete_shown_reported 0
+set -g _komplete_last_cwd $PWD
+# Whether a suggestion is drawn after the cursor, and the global
+# fish_autosuggestion_enabled it replaced ("unset" when there was none).
+set -g _komplete_drawn 0
+set -g _komplete_autosuggest unset
+
+# Identifies this shell to `komplete pause` and the daemon.
+set -gx KOMPLETE_SESSION $fish_pid
+
+function _komplete_buffer
+    commandline | string collect
+end
+
+function _komplete_ensure_daemon
+    # Refuse a runtime dir someone else created for us.
+    test -d $_komplete_runtime_dir; and test -O $_komplete_runtime_dir; or return 1
+
+    # The daemon exits on its own when idle, removing its socket and pidfile.
+    if test -S $_komplete_socket; and test -r $_komplete_pidfile
+        read -l pid <$_komplete_pidfile
+        and kill -0 $pid 2>/dev/null
+        and return 0
+    end
+
+    command $_komplete_bin daemon --socket $_komplete_socket >/dev/null 2>&1 &
+    disown $last_pid 2>/dev/null
+
+    for i in (seq 20)
+        test -S $_komplete_socket; and return 0
+        sleep 0.05
+    end
+    return 1
+end
+
+# Tells the daemon what happened to the last suggestion shown:
+# _komplete_report accept|partial|ignore
+function _komplete_report
+    test -n "$_komplete_shown"; and test $_komplete_shown_reported -eq 0; or return
+    set -g _komplete_shown_reported 1
+    command $_komplete_bin query --socket $_komplete_socket --type feedback --cwd $PWD \
+        --event $argv[1] --suggestion $_komplete_shown --accepted $_komplete_shown_taken \
+        -- $_komplete_shown_buffer >/dev/null 2>&1 &
+    disown $last_pid 2>/dev/null
+end
+
+# Starts the helper that keeps this shell's connection to the daemon. The
+# daemon may answer a request more than once: a history match first, then a
+# final response with the model's suggestion. Each answer replaces the result
+# file and wakes us with SIGUSR1, then, once fish has had time to repaint,
+# with SIGUSR2 to draw it.
+function _komplete_start_stream
+    command rm -f $_komplete_fifo
+    command mkfifo -m 600 $_komplete_fifo; or return 1
+    # Opened for reading and writing, the fifo doesn't reach end of file
+    # between our writes.
+    command sh -c '
+        exec 3<>"$5"
+        "$1" query --stream --socket "$2" --shell fish --parent "$4" <&3 2>/dev/null | while IFS= read -r line; do
+            printf "%s\n" "$line" >"$3.tmp" && mv "$3.tmp" "$3" && kill -USR1 "$4" && sleep 0.02 && kill -USR2 "$4"
+        done' sh $_komplete_bin $_komplete_socket $_komplete_result_file $fish_pid $_komplete_fifo &
+    set -g _komplete_stream_pid $last_pid
+    disown $last_pid 2>/dev/null
+end
+
+# Sends the helper one request as key=value fields, starting another helper
+# if it has gone away: with nothing reading the fifo, writing would hang.
+function _komplete_send
+    if test -z "$_komplete_stream_pid"; or not kill -0 $_komplete_stream_pid 2>/dev/null
+        _komplete_start_stream; or return
+    end
+    string join0 -- $argv '' >$_komplete_fifo
+end
+
+# Tells the daemon this shell's functions, aliases among them, and
+# abbreviations when they change, since it can't see them itself.
+function _komplete_send_names
+    set -l names (string join ' ' -- (functions -n) (abbr --list))
+    test "$names" = "$_komplete_names"; and return
+    set -g _komplete_names $names
+    _komplete_send type=names names=$names
+end
+
+# Cancels the request in flight, if any, and drops an answer not yet shown.
+function _komplete_cancel
+    if test $_komplete_pending -eq 1
+        _komplete_send type=cancel
+        set -g _komplete_pending 0
+    end
+    command rm -f $_komplete_result_file 2>/dev/null
+end
+
+function _komplete_paused
+    test -e $_komplete_state_dir/paused; or test -e $_komplete_state_dir/paused-$KOMPLETE_SESSION
+end
+
+# Asks for a suggestion for the line, superseding the last request.
+function _komplete_query_daemon
+    _komplete_ensure_daemon; or return
+
+    # fish_history names the history session; empty means none is kept.
+    set -l histfile ""
+    set -l session fish
+    set -q fish_history; and set session $fish_history
+    if test -n "$session"
+        set -l data $HOME/.local/share
+        test -n "$XDG_DATA_HOME"; and set data $XDG_DATA_HOME
+        set histfile $data/fish/$session"_history"
+    end
+
+    _komplete_send type=complete buffer=$argv[1] cwd=$PWD path=(string join : -- $PATH) histfile=$histfile
+    set -g _komplete_pending 1
+end
+
+# Reports whether a suggestion can be drawn after the cursor: not while the
+# pager is open or the line runs over several rows, which fish lays out
+# itself and redraws around anything we put there.
+function _komplete_can_draw
+    not commandline --paging-mode; and test (count (commandline)) -le 1
+end
+
+# Reports whether the current suggestion continues the line at the cursor.
+function _komplete_showing
+    test -n "$_komplete_suggestion"; or return 1
+    _komplete_can_draw; or return 1
+    set -l buffer (_komplete_buffer)
+    set -l n (string length -- "$buffer")
+    test $n -gt 0; and test (commandline -C) -eq $n; or return 1
+    set -l head (string sub -l $n -- $_komplete_suggestion)
+    test "$_komplete_suggestion" != "$buffer"; and test "$head" = "$buffer"
+end
+
+# Reports whether the suggestion goes on past the line with $argv[1].
+function _komplete_continues
+    _komplete_showing; or return 1
+    set -l next (_komplete_buffer)$argv[1]
+    set -l n (string length -- "$next")
+    test (string length -- "$_komplete_suggestion") -gt $n
+    and test (string sub -l $n -- "$_komplete_suggestion") = "$next"
+end
+
+# Draws the rest of the suggestion after the cursor, $argv[1] cells on when
+# fish is about to show that much more of the line. Wrapping is off while
+# drawing, so a long suggestion is cut at the edge of the screen instead of
+# scrolling it.
+function _komplete_paint
+    set -l buffer (_komplete_buffer)
+    set -l rest (string sub -s (math (string length -- "$buffer") + 1) -- $_komplete_suggestion)
+    if test $argv[1] -gt 0
+        printf '\e7\e[%dC\e[?7l\e[K\e[90m%s\e[0m\e[?7h\e8' $argv[1] $rest >/dev/tty
+    else
+        printf '\e7\e[?7l\e[K\e[90m%s\e[0m\e[?7h\e8' $rest >/dev/tty
+    end
+end
+
+function _komplete_restore_autosuggest
+    if test "$_komplete_autosuggest" = unset
+        set -e -g fish_autosuggestion_enabled
+    else
+        set -g fish_autosuggestion_enabled $_komplete_autosuggest
+    end
+end
+
+# Takes the drawn suggestion off the screen. Call it before changing the
+# line, while the screen still shows the line as it was.
+function _komplete_erase
+    test $_komplete_drawn -eq 1; or return
+    set -g _komplete_drawn 0
+    _komplete_restore_autosuggest
+
+    # The suggestion starts where the line ends, which the cursor may have
+    # been moved back from.
+    set -l buffer (_komplete_buffer)
+    set -l after (string sub -s (math (commandline -C) + 1) -- "$buffer")
+    string match -q -r -- '\n' "$after"; and return
+    set -l ahead (string length -- "$after")
+    if test $ahead -gt 0
+        printf '\e7\e[%dC\e[K\e8' $ahead >/dev/tty
+    else
+        printf '\e[K' >/dev/tty
+    end
+end
+
+function _komplete_on_result --on-signal SIGUSR1
+    test -s $_komplete_result_file; or return
+    read -l suggestion <$_komplete_result_file
+    test -n "$suggestion"; or return
+
+    set -l previous $_komplete_suggestion
+    set -g _komplete_suggestion $suggestion
+    if not _komplete_showing
+        set -g _komplete_suggestion $previous
+        return
+    end
+    if test "$suggestion" != "$_komplete_shown"
+        set -g _komplete_shown $suggestion
+        set -g _komplete_shown_buffer (_komplete_buffer)
+        set -g _komplete_shown_taken ""
+        set -g _komplete_shown_reported 0
+    end
+    # Fish would draw its own suggestion over ours as the user types along,
+    # so it's off while ours shows; the repaint takes down the one showing.
+    if test $_komplete_drawn -eq 0
+        set -g _komplete_drawn 1
+        set -g _komplete_autosuggest unset
+        set -q -g fish_autosuggestion_enabled; and set -g _komplete_autosuggest $fish_autosuggestion_enabled
+        set -g fish_autosuggestion_enabled 0
+        commandline -f repaint
+    end
+end
+
+function _komplete_on_draw --on-signal SIGUSR2
+    test $_komplete_drawn -eq 1; and _komplete_showing; and _komplete_paint 0
+end
+
+# fish redraws the line when the terminal is resized, wiping the suggestion;
+# draw it again once fish is done.
+function _komplete_on_resize --on-signal SIGWINCH
+    test $_komplete_drawn -eq 1; or return
+    command sh -c 'sleep 0.05 && kill -USR2 "$1"' sh $fish_pid &
+    disown $last_pid 2>/dev/null
+end
+
+# Runs after every typed character: keeps showing a suggestion the user is
+# typing along, or asks the daemon for a new one.
+function _komplete_changed
+    _komplete_showing; and return
+
+    set -g _komplete_suggestion ""
+    _komplete_cancel
+    _komplete_can_draw; or return
+
+    set -l buffer (_komplete_buffer)
+    test (string length -- "$buffer") -ge $_komplete_min_chars; or return
+    string match -q -r '^cd( |$)' -- $buffer; and return
+    _komplete_paused; and return
+
+    _komplete_query_daemon $buffer
+end
+
+function _komplete_insert
+    if _komplete_continues $argv[1]
+        commandline -i -- $argv[1]
+        test $_komplete_drawn -eq 1; and _komplete_paint 1
+        return
+    end
+    _komplete_erase
+    commandline -i -- $argv[1]
+    _komplete_changed
+end
+
+# Runs before fish inserts a space and expands any abbreviation before it.
+function _komplete_before_space
+    if _komplete_continues ' '; and not abbr --query -- (commandline -t) 2>/dev/null
+        test $_komplete_drawn -eq 1; and _komplete_paint 1
+    else
+        _komplete_erase
+    end
+end
+
+function _komplete_accept
+    if not _komplete_showing
+        commandline -f complete
+        return
+    end
+    _komplete_report accept
+    _komplete_cancel
+    _komplete_erase
+    commandline -r -- $_komplete_suggestion
+    commandline -f end-of-line
+    set -g _komplete_suggestion ""
+end
+
+# Takes the next word of the suggestion, or runs the readline function
+# $argv[1] when there is none.
+function _komplete_accept_word
+    if not _komplete_showing
+        commandline -f $argv[1]
+        return
+    end
+    set -l buffer (_komplete_buffer)
+    set -l rest (string sub -s (math (string length -- "$buffer") + 1) -- $_komplete_suggestion)
+    set -l word (string match -r -- '^[^ ]* ?' $rest)
+
+    if test "$buffer$word" = "$_komplete_shown"
+        _komplete_erase
+        commandline -i -- $word
+        _komplete_report accept
+        set -g _komplete_suggestion ""
+    else
+        commandline -i -- $word
+        set -g _komplete_shown_taken (_komplete_buffer)
+        test $_komplete_drawn -eq 1; and _komplete_paint (string length -- "$word")
+    end
+end
+
+function _komplete_accept_line
+    set -l buffer (_komplete_buffer)
+    if test -n "$_komplete_shown_taken"
+        _komplete_report partial
+    else if test -n "$_komplete_shown"; and test "$buffer" != "$_komplete_shown"
+        _komplete_report ignore
+    end
+    _komplete_cancel
+    _komplete_erase
+    set -g _komplete_suggestion ""
+    commandline -f execute
+end
+
+# The keys that insert themselves, each bound to _komplete_insert.
+set -g _komplete_chars (string split '' -- '!"#$%&\'()*+,-./0123456789:;<=>?@ABCDEFGHIJKLMNOPQRSTUVWXYZ[\\]^_`abcdefghijklmnopqrstuvwxyz{|}~')
+set -g _komplete_modes
+
+function _komplete_bind
+    set -l modes default
+    test "$fish_key_bindings" = fish_vi_key_bindings; and set modes insert
+    set -g _komplete_modes $_komplete_modes $modes
+
+    for mode in $modes
+        for c in $_komplete_chars
+            bind -M $mode -- $c "_komplete_insert "(string escape -- $c) 2>/dev/null
+        end
+
+        # fish 4 names keys; older versions bind raw sequences.
+        # Space still expands abbreviations, before we look at the line.
+        if test (string replace -r '\..*' '' -- $version) -ge 4
+            bind -M $mode space _komplete_before_space self-insert expand-abbr _komplete_changed
+            bind -M $mode tab _komplete_accept
+            bind -M $mode shift-tab '_komplete_accept_word complete-and-search'
+            bind -M $mode alt-f '_komplete_accept_word forward-word'
+            bind -M $mode enter _komplete_accept_line
+        else
+            bind -M $mode ' ' _komplete_before_space self-insert expand-abbr _komplete_changed
+            bind -M $mode \t _komplete_accept
+            bind -M $mode -k btab '_komplete_accept_word complete-and-search'
+            bind -M $mode \ef '_komplete_accept_word forward-word'
+            bind -M $mode \r _komplete_accept_line
+        end
+    end
+end
+_komplete_bind
+
+# Switching key bindings drops ours.
+function _komplete_rebind --on-variable fish_key_bindings
+    _komplete_bind
+end
+
+# Reports the command that just finished, so suggestions see it before fish
+# writes it to the history file.
+function _komplete_preexec --on-event fish_preexec
+    set -g _komplete_last_cwd $PWD
+end
+
+function _komplete_postexec --on-event fish_postexec
+    set -l exit_status $status
+    if test -n "$argv[1]"; and not _komplete_paused
+        command $_komplete_bin query --socket $_komplete_socket --type command --cwd $_komplete_last_cwd \
+            --command $argv[1] --exit $exit_status --duration {$CMD_DURATION}ms >/dev/null 2>&1 &
+        disown $last_pid 2>/dev/null
+    end
+end
+
+function _komplete_prompt --on-event fish_prompt
+    if test $_komplete_drawn -eq 1
+        set -g _komplete_drawn 0
+        _komplete_restore_autosuggest
+    end
+    set -g _komplete_suggestion ""
+    set -g _komplete_shown ""
+    set -g _komplete_shown_taken ""
+    _komplete_cancel
+    _komplete_send_names
+end
+
+function _komplete_cleanup --on-event fish_exit
+    if test $_komplete_drawn -eq 1
+        set -g _komplete_drawn 0
+        _komplete_restore_autosuggest
+    end
+    _komplete_cancel
+    test -n "$_komplete_stream_pid"; and kill -TERM $_komplete_stream_pid 2>/dev/null
+    command rm -f $_komplete_fifo 2>/dev/null
+    command rm -f $_komplete_state_dir/paused-$KOMPLETE_SESSION 2>/dev/null
+end
+
+# `emit komplete_unload` turns the plugin off in this shell, giving fish back
+# its own autosuggestions and key bindings.
+function _komplete_unload --on-event komplete_unload
+    _komplete_erase
+    _komplete_cleanup
+    set -g _komplete_suggestion ""
+    functions -e _komplete_rebind _komplete_on_result _komplete_on_draw _komplete_on_resize \
+        _komplete_preexec _komplete_postexec _komplete_prompt _komplete_cleanup
+
+    # Erasing our bindings uncovers fish's own for the same keys.
+    for mode in (printf '%s\n' $_komplete_modes | sort -u)
+        bind -e -M $mode -- $_komplete_chars 2>/dev/null
+        if test (string replace -r '\..*' '' -- $version) -ge 4
+            bind -e -M $mode space tab shift-tab alt-f enter 2>/dev/null
+        else
+            bind -e -M $mode ' ' \t \ef \r 2>/dev/null
+            bind -e -M $mode -k btab 2>/dev/null
+        end
+    end
+    set -g _komplete_modes
+    commandline -f repaint
+end
+
+_komplete_ensure_daemon
+
+function k --wraps komplete
+    komplete $argv
+end
+
+end